@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Decides which senders are allowed to forward keys to the server, based on their
+// IP address and device name.
+type _AccessControl struct {
+	allowedNetworks []*net.IPNet
+	deniedNetworks  []*net.IPNet
+	allowedDevices  map[string]bool
+	deniedDevices   map[string]bool
+	localSubnetOnly bool
+	allowedKeys     map[Key]bool
+	senderKeys      map[string]map[Key]bool
+}
+
+// Create a new access control object using the allow- and deny-lists of the given
+// server configuration.
+// Returns an error in case one of the configured networks is invalid.
+func NewAccessControl(config *ServerConfiguration) (*_AccessControl, error) {
+	var err error
+	ret := new(_AccessControl)
+	ret.localSubnetOnly = config.LocalSubnetOnly
+	if ret.allowedNetworks, err = parseNetworks(config.AllowedNetworks); err != nil {
+		return nil, err
+	}
+	if ret.deniedNetworks, err = parseNetworks(config.DeniedNetworks); err != nil {
+		return nil, err
+	}
+	ret.allowedDevices = deviceSet(config.AllowedDevices)
+	ret.deniedDevices = deviceSet(config.DeniedDevices)
+	ret.allowedKeys = keySet(config.AllowedKeys)
+	ret.senderKeys = make(map[string]map[Key]bool, len(config.SenderKeys))
+	for device, keys := range config.SenderKeys {
+		ret.senderKeys[strings.ToLower(strings.TrimSpace(device))] = keySet(keys)
+	}
+	return ret, nil
+}
+
+// Checks, whether packets from the given IP address are accepted.
+// Deny-list entries take precedence over allow-list entries. An empty allow-list
+// allows all addresses.
+// Returns an error describing the reason in case the address is rejected.
+func (t *_AccessControl) CheckAddress(ip net.IP) error {
+	if containsIP(t.deniedNetworks, ip) {
+		return fmt.Errorf("address %s is denied", ip)
+	}
+	if len(t.allowedNetworks) > 0 && !containsIP(t.allowedNetworks, ip) {
+		return fmt.Errorf("address %s is not allowed", ip)
+	}
+	if t.localSubnetOnly && !isLocalSubnet(ip) {
+		return fmt.Errorf("address %s is not within a local subnet", ip)
+	}
+	return nil
+}
+
+// Checks, whether packets from the device with the given name are accepted.
+// Device names are compared case-insensitively.
+// Returns an error describing the reason in case the device is rejected.
+func (t *_AccessControl) CheckDevice(name string) error {
+	key := strings.ToLower(name)
+	if t.deniedDevices[key] {
+		return fmt.Errorf("device '%s' is denied", name)
+	}
+	if len(t.allowedDevices) > 0 && !t.allowedDevices[key] {
+		return fmt.Errorf("device '%s' is not allowed", name)
+	}
+	return nil
+}
+
+// Checks, whether the device with the given name may emit the given key.
+// Keys configured for the device replace the keys allowed for all senders.
+// Returns an error describing the reason in case the key is rejected.
+func (t *_AccessControl) CheckKey(device string, key Key) error {
+	keys, ok := t.senderKeys[strings.ToLower(device)]
+	if !ok {
+		keys = t.allowedKeys
+	}
+	if !keys[key] {
+		return fmt.Errorf("key %s is not allowed for device '%s'", key, device)
+	}
+	return nil
+}
+
+// Parses the given list of networks in CIDR notation.
+// Plain IP addresses are treated as single-host networks.
+func parseNetworks(networks []string) ([]*net.IPNet, error) {
+	ret := make([]*net.IPNet, 0, len(networks))
+	for _, network := range networks {
+		network = strings.TrimSpace(network)
+		if !strings.Contains(network, "/") {
+			ip, _ := parseIPZone(stripBrackets(network))
+			if ip == nil {
+				return nil, fmt.Errorf("invalid network '%s'", network)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			ret = append(ret, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network '%s'", network)
+		}
+		ret = append(ret, ipnet)
+	}
+	return ret, nil
+}
+
+func deviceSet(devices []string) map[string]bool {
+	ret := make(map[string]bool, len(devices))
+	for _, device := range devices {
+		ret[strings.ToLower(strings.TrimSpace(device))] = true
+	}
+	return ret
+}
+
+func keySet(keys []Key) map[Key]bool {
+	ret := make(map[Key]bool, len(keys))
+	for _, key := range keys {
+		ret[key] = true
+	}
+	return ret
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true, if the given IP address is a loopback address or lies within the
+// subnet of one of the local network interfaces.
+func isLocalSubnet(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
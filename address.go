@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Removes the square brackets around an IPv6 literal, e.g. "[fe80::1%eth0]".
+func stripBrackets(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// Splits the given address into host and port.
+// The address may either be a plain host (hostname, IPv4 or IPv6 literal, with or without
+// brackets and zone ID) or a "host:port" pair. The default port is used if the address
+// doesn't contain one.
+func splitAddress(address string, defaultPort uint64) (string, uint64, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return stripBrackets(address), defaultPort, nil
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in address '%s'", address)
+	}
+	return host, p, nil
+}
+
+// Returns the network type to use for the given configuration value.
+// Defaults to dual-stack.
+func getNetwork(network string) string {
+	switch network {
+	case NETWORK_IPV4_ONLY, NETWORK_IPV6_ONLY:
+		return network
+	}
+	return NETWORK_DUAL_STACK
+}
+
+// Resolves the remote address of the given host and port.
+// The hostname may be an IPv6 literal, optionally enclosed in brackets and including a zone
+// ID (e.g. "fe80::1%eth0").
+func ResolveRemoteAddress(hostname string, port uint64) (*net.UDPAddr, error) {
+	host := stripBrackets(strings.TrimSpace(hostname))
+	return net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.FormatUint(port, 10)))
+}
+
+// Resolves the local address to send packets from.
+// The source may be empty (let the operating system choose), an IP literal or the name of a
+// network interface. In the latter case, the first address of the interface matching the
+// address family of the given remote address will be used.
+func ResolveSourceAddress(source string, remote *net.UDPAddr) (*net.UDPAddr, error) {
+	source = strings.TrimSpace(source)
+	if len(source) == 0 {
+		return nil, nil
+	}
+	host, port, err := splitAddress(source, 0)
+	if err != nil {
+		return nil, err
+	}
+	ip, zone := parseIPZone(host)
+	if ip != nil {
+		return &net.UDPAddr{IP: ip, Port: int(port), Zone: zone}, nil
+	}
+
+	iface, err := net.InterfaceByName(host)
+	if err != nil {
+		return nil, fmt.Errorf("unknown source address or interface '%s'", source)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	wantIPv4 := remote.IP.To4() != nil
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || (ipnet.IP.To4() != nil) != wantIPv4 {
+			continue
+		}
+		ret := &net.UDPAddr{IP: ipnet.IP, Port: int(port)}
+		if ipnet.IP.IsLinkLocalUnicast() {
+			ret.Zone = iface.Name
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("interface '%s' has no address matching the remote host '%s'", host, remote.IP)
+}
+
+// Parses an IP literal with an optional zone ID ("fe80::1%eth0").
+// Returns nil if the given string is not an IP literal.
+func parseIPZone(host string) (net.IP, string) {
+	zone := ""
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		host, zone = host[:i], host[i+1:]
+	}
+	return net.ParseIP(host), zone
+}
+
+// Returns the list of local addresses the server should listen on.
+// Without explicitly configured listen addresses, the server listens on the wildcard address
+// of the configured network type.
+func ListenAddresses(config *ServerConfiguration) ([]*net.UDPAddr, error) {
+	network := getNetwork(config.Network)
+	addresses := config.ListenAddresses
+	if len(addresses) == 0 {
+		switch network {
+		case NETWORK_IPV4_ONLY:
+			addresses = []string{"0.0.0.0"}
+		case NETWORK_IPV6_ONLY:
+			addresses = []string{"::"}
+		default:
+			addresses = []string{""}
+		}
+	}
+
+	ret := make([]*net.UDPAddr, 0, len(addresses))
+	for _, address := range addresses {
+		host, port, err := splitAddress(strings.TrimSpace(address), config.Port)
+		if err != nil {
+			return nil, err
+		}
+		addr, err := net.ResolveUDPAddr(network, net.JoinHostPort(host, strconv.FormatUint(port, 10)))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, addr)
+	}
+	return ret, nil
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/howeyc/gopass"
+	"io"
+	"log"
+	"os"
+)
+
+// Version of the configuration bundle format written by this program.
+const CONFIG_BUNDLE_VERSION = 1
+
+// Environment variable holding the passphrase of configuration bundles.
+const ENV_BUNDLE_PASSPHRASE = "KEYFWD_BUNDLE_PASSPHRASE"
+
+// Name of the encrypted secrets within a bundle, used as additional data.
+const CONFIG_BUNDLE_SECRETS = "danieljoos/keyfwd/bundle"
+
+// Portable configuration of the client and the server, written by "config export".
+// Settings specific to the exporting machine (device name, secret storage) are left out.
+type _ConfigBundle struct {
+	BundleVersion int
+	Client        json.RawMessage `json:",omitempty"`
+	Server        json.RawMessage `json:",omitempty"`
+	// Encrypted secrets, nil if the bundle was exported without secrets.
+	Secrets *_BundleSecrets `json:",omitempty"`
+}
+
+// Secrets of a bundle, encrypted using AES-GCM with a key derived from the bundle's
+// passphrase.
+type _BundleSecrets struct {
+	Salt       []byte
+	Iterations int
+	Data       []byte
+}
+
+// Secrets of a bundle, after decryption.
+// The secrets of client entries and key generations are mapped by name and ID.
+type _BundleSecretValues struct {
+	Client         []byte            `json:",omitempty"`
+	Server         []byte            `json:",omitempty"`
+	Clients        map[string][]byte `json:",omitempty"`
+	KeyGenerations map[string][]byte `json:",omitempty"`
+}
+
+// Writes the configuration of the current profile as portable bundle.
+// Without arguments, all stored roles are exported.
+// Supported flags: -secrets (include the secrets, encrypted by a passphrase) and -o (file
+// to write to instead of stdout)
+func ExportConfiguration(args []string) {
+	flags := flag.NewFlagSet("config export", flag.ExitOnError)
+	withSecrets := flags.Bool("secrets", false, "include the secrets, encrypted by a passphrase")
+	output := flags.String("o", "", "file to write the bundle to instead of stdout")
+	flags.Parse(args)
+	roles := configuredRoles(flags.Args())
+	if len(roles) == 0 {
+		log.Fatal("No configuration found")
+	}
+
+	bundle := &_ConfigBundle{BundleVersion: CONFIG_BUNDLE_VERSION}
+	secrets := new(_BundleSecretValues)
+	var err error
+	for _, role := range roles {
+		switch role {
+		case "client":
+			configuration, err := LoadClientConfiguration()
+			if errors.Is(err, ErrConfigurationUnreadable) {
+				log.Fatal(err)
+			}
+			secrets.Client = configuration.Secret
+			configuration.DeviceName = ""
+			configuration.SecretStore = ""
+			if bundle.Client, err = json.Marshal(configuration); err != nil {
+				log.Fatal(err)
+			}
+		case "server":
+			configuration, err := LoadServerConfiguration()
+			if errors.Is(err, ErrConfigurationUnreadable) {
+				log.Fatal(err)
+			}
+			secrets.Server = configuration.Secret
+			secrets.Clients = make(map[string][]byte)
+			for _, client := range configuration.Clients {
+				secrets.Clients[client.Name] = client.Secret
+			}
+			secrets.KeyGenerations = make(map[string][]byte)
+			for _, generation := range configuration.KeyGenerations {
+				secrets.KeyGenerations[generation.ID] = generation.Secret
+			}
+			configuration.SecretStore = ""
+			if bundle.Server, err = json.Marshal(configuration); err != nil {
+				log.Fatal(err)
+			}
+		default:
+			log.Fatal(fmt.Sprintf("Unknown role '%s'", role))
+		}
+	}
+	if *withSecrets {
+		if bundle.Secrets, err = sealBundleSecrets(secrets); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	data = append(data, '\n')
+	if len(*output) == 0 {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*output, data, 0600); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Configuration exported to %s\n", *output)
+}
+
+// Applies a bundle written by "config export" to the current profile.
+// Existing configurations are only replaced using -force. Without secrets in the bundle,
+// the secrets of the existing configuration are kept.
+// Supported flags: -secret-store (secret storage to use) and -force
+func ImportConfiguration(args []string) {
+	flags := flag.NewFlagSet("config import", flag.ExitOnError)
+	force := flags.Bool("force", false, "replace existing configurations")
+	flags.String("secret-store", "", "secret storage to use: wincred, secret-service or file")
+	flags.Parse(args)
+	settings := loadSettings(flags, map[string]string{"secret-store": ENV_SECRET_STORE})
+	if flags.NArg() < 1 {
+		log.Fatal("Missing bundle file")
+	}
+
+	bundle, err := readConfigBundle(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var secrets *_BundleSecretValues
+	if bundle.Secrets != nil {
+		if secrets, err = openBundleSecrets(bundle.Secrets); err != nil {
+			log.Fatal(err)
+		}
+	}
+	store := GetConfigStore()
+	if !*force {
+		if len(bundle.Client) > 0 && store.ClientConfigurationExists() {
+			log.Fatal(fmt.Sprintf("Profile '%s' has a client configuration already (use -force to replace it)", store.Profile()))
+		}
+		if len(bundle.Server) > 0 && store.ServerConfigurationExists() {
+			log.Fatal(fmt.Sprintf("Profile '%s' has a server configuration already (use -force to replace it)", store.Profile()))
+		}
+	}
+
+	// Both roles are checked before storing any of them.
+	var client, previousClient *ClientConfiguration
+	var server, previousServer *ServerConfiguration
+	if len(bundle.Client) > 0 {
+		client, previousClient = decodeClientImport(store, bundle.Client, secrets, settings["secret-store"])
+	}
+	if len(bundle.Server) > 0 {
+		server, previousServer = decodeServerImport(store, bundle.Server, secrets, settings["secret-store"])
+	}
+	if client != nil {
+		if err := store.StoreClientConfiguration(client); err != nil {
+			log.Fatal(err)
+		}
+		if previousClient != nil && normalizeSecretStore(previousClient.SecretStore) != normalizeSecretStore(client.SecretStore) {
+			if err := deleteClientSecret(previousClient); err != nil {
+				logError("Unable to remove the previous client secret: %s", err)
+			}
+		}
+		fmt.Printf("Client configuration imported to profile '%s'\n", store.Profile())
+	}
+	if server != nil {
+		if err := store.StoreServerConfiguration(server); err != nil {
+			log.Fatal(err)
+		}
+		if previousServer != nil {
+			removeReplacedServerSecrets(previousServer, server)
+		}
+		fmt.Printf("Server configuration imported to profile '%s'\n", store.Profile())
+	}
+	if secrets == nil {
+		fmt.Println("The bundle contains no secrets; use 'keyfwd config check' to find missing ones")
+	}
+}
+
+// Returns the client configuration of a bundle, using the given secrets and secret storage,
+// along with the existing configuration it replaces (nil, if there is none).
+// Without secrets in the bundle, the existing secret is kept.
+func decodeClientImport(store ConfigStore, section json.RawMessage, secrets *_BundleSecretValues, secretStore string) (*ClientConfiguration, *ClientConfiguration) {
+	configuration := NewClientConfiguration()
+	if err := json.Unmarshal(section, configuration); err != nil {
+		log.Fatal(fmt.Errorf("%w: Client: %s", ErrConfigurationUnreadable, err))
+	}
+	configuration.Profile = store.Profile()
+	configuration.SecretStore = secretStore
+	var previous *ClientConfiguration
+	if store.ClientConfigurationExists() {
+		var err error
+		previous, err = store.LoadClientConfiguration()
+		if errors.Is(err, ErrConfigurationUnreadable) {
+			log.Fatal(err)
+		}
+	}
+	if secrets != nil {
+		configuration.Secret = secrets.Client
+		if err := configuration.Validate(); err != nil {
+			log.Fatal(err)
+		}
+	} else if previous != nil {
+		configuration.Secret = previous.Secret
+	}
+	return configuration, previous
+}
+
+// Returns the server configuration of a bundle, using the given secrets and secret storage,
+// along with the existing configuration it replaces (nil, if there is none).
+// Without secrets in the bundle, the existing secrets are kept.
+func decodeServerImport(store ConfigStore, section json.RawMessage, secrets *_BundleSecretValues, secretStore string) (*ServerConfiguration, *ServerConfiguration) {
+	configuration := NewServerConfiguration()
+	if err := json.Unmarshal(section, configuration); err != nil {
+		log.Fatal(fmt.Errorf("%w: Server: %s", ErrConfigurationUnreadable, err))
+	}
+	configuration.Profile = store.Profile()
+	configuration.SecretStore = secretStore
+	var previous *ServerConfiguration
+	if store.ServerConfigurationExists() {
+		var err error
+		previous, err = store.LoadServerConfiguration()
+		if errors.Is(err, ErrConfigurationUnreadable) {
+			log.Fatal(err)
+		}
+	}
+	if secrets != nil {
+		configuration.Secret = secrets.Server
+		for i := range configuration.Clients {
+			configuration.Clients[i].Secret = secrets.Clients[configuration.Clients[i].Name]
+		}
+		for i := range configuration.KeyGenerations {
+			configuration.KeyGenerations[i].Secret = secrets.KeyGenerations[configuration.KeyGenerations[i].ID]
+		}
+		if err := configuration.Validate(); err != nil {
+			log.Fatal(err)
+		}
+	} else if previous != nil {
+		configuration.Secret = previous.Secret
+		for i := range configuration.Clients {
+			if client := previous.FindClient(configuration.Clients[i].Name); client != nil {
+				configuration.Clients[i].Secret = client.Secret
+			}
+		}
+		for i := range configuration.KeyGenerations {
+			for _, generation := range previous.KeyGenerations {
+				if generation.ID == configuration.KeyGenerations[i].ID {
+					configuration.KeyGenerations[i].Secret = generation.Secret
+				}
+			}
+		}
+	}
+	return configuration, previous
+}
+
+// Removes the secrets of the previous server configuration, which the new configuration
+// doesn't use anymore.
+func removeReplacedServerSecrets(previous *ServerConfiguration, configuration *ServerConfiguration) {
+	if normalizeSecretStore(previous.SecretStore) != normalizeSecretStore(configuration.SecretStore) {
+		if err := deleteServerSecrets(previous); err != nil {
+			logError("Unable to remove the previous server secrets: %s", err)
+		}
+		return
+	}
+	for _, client := range previous.Clients {
+		if configuration.FindClient(client.Name) == nil {
+			if err := DeleteServerClientSecret(previous, client.Name); err != nil {
+				logError("Unable to remove the secret of client '%s': %s", client.Name, err)
+			}
+		}
+	}
+	ids := make(map[string]bool, len(configuration.KeyGenerations))
+	for _, generation := range configuration.KeyGenerations {
+		ids[generation.ID] = true
+	}
+	for _, generation := range previous.KeyGenerations {
+		if !ids[generation.ID] {
+			if err := DeleteServerKeySecret(previous, generation.ID); err != nil {
+				logError("Unable to remove the secret of key generation '%s': %s", generation.ID, err)
+			}
+		}
+	}
+}
+
+// Reads the bundle from the file with the given name, "-" reads from stdin.
+func readConfigBundle(name string) (*_ConfigBundle, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ret := new(_ConfigBundle)
+	if err := json.Unmarshal(data, ret); err != nil {
+		return nil, fmt.Errorf("unable to read configuration bundle '%s': %s", name, err)
+	}
+	if ret.BundleVersion < 1 || ret.BundleVersion > CONFIG_BUNDLE_VERSION {
+		return nil, fmt.Errorf("unsupported configuration bundle version %d", ret.BundleVersion)
+	}
+	return ret, nil
+}
+
+// Encrypts the given secrets using a passphrase, which is asked for.
+func sealBundleSecrets(secrets *_BundleSecretValues) (*_BundleSecrets, error) {
+	passphrase, err := getBundlePassphrase(true)
+	if err != nil {
+		return nil, err
+	}
+	ret := &_BundleSecrets{Salt: make([]byte, SECRET_FILE_SALT_SIZE), Iterations: SECRET_FILE_ITERATIONS}
+	if _, err := rand.Read(ret.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := newPassphraseCipher(passphrase, ret.Salt, ret.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+	if ret.Data, err = sealSecret(aead, CONFIG_BUNDLE_SECRETS, data); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Decrypts the given secrets using a passphrase, which is asked for.
+func openBundleSecrets(secrets *_BundleSecrets) (*_BundleSecretValues, error) {
+	if err := checkKeyDerivation(secrets.Salt, secrets.Iterations); err != nil {
+		return nil, fmt.Errorf("invalid secrets of the configuration bundle: %s", err)
+	}
+	passphrase, err := getBundlePassphrase(false)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newPassphraseCipher(passphrase, secrets.Salt, secrets.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	data, err := openSecret(aead, CONFIG_BUNDLE_SECRETS, secrets.Data)
+	if err != nil {
+		return nil, errors.New("wrong passphrase for the configuration bundle")
+	}
+	ret := new(_BundleSecretValues)
+	if err := json.Unmarshal(data, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Returns the passphrase of a bundle.
+func getBundlePassphrase(confirm bool) ([]byte, error) {
+	return getPassphrase(ENV_BUNDLE_PASSPHRASE, "configuration bundle", confirm)
+}
+
+// Returns the passphrase of the given purpose.
+// Uses the given environment variable or asks for the passphrase on the terminal, twice if
+// 'confirm' is set. The prompt is written to stderr, so it doesn't mix with data written to
+// stdout.
+func getPassphrase(variable string, purpose string, confirm bool) ([]byte, error) {
+	if value, ok := os.LookupEnv(variable); ok {
+		if len(value) == 0 {
+			return nil, fmt.Errorf("missing passphrase for the %s (set %s)", purpose, variable)
+		}
+		return []byte(value), nil
+	}
+	ret, err := gopass.GetPasswdPrompt(fmt.Sprintf("Passphrase for the %s: ", purpose), false, os.Stdin, os.Stderr)
+	if err != nil || len(ret) == 0 {
+		return nil, fmt.Errorf("missing passphrase for the %s (set %s)", purpose, variable)
+	}
+	if confirm {
+		repeated, err := gopass.GetPasswdPrompt("Repeat passphrase: ", false, os.Stdin, os.Stderr)
+		if err != nil || string(repeated) != string(ret) {
+			return nil, errors.New("the passphrases don't match")
+		}
+	}
+	return ret, nil
+}
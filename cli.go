@@ -0,0 +1,437 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// Exit codes of the program.
+// Failing commands exit using log.Fatal, which uses EXIT_FAILURE.
+const (
+	EXIT_SUCCESS = 0
+	EXIT_FAILURE = 1
+	// Invalid command line, as used by the flag package.
+	EXIT_USAGE = 2
+)
+
+// Name of the program within usage texts.
+const PROGRAM_NAME = "keyfwd"
+
+// Version of the program, set when building:
+// go build -ldflags "-X main.version=1.2.3"
+var version = "dev"
+
+// Command of the command line.
+// Commands either run a function or have subcommands.
+type _Command struct {
+	Name string
+	// Arguments, shown in usage texts.
+	Args    string
+	Summary string
+	// Number of required arguments.
+	MinArgs int
+	// Values of the first argument, offered by the shell completion.
+	Choices []string
+	// The command parses its own flags, including -h.
+	HasFlags bool
+	Run      func(args []string)
+	Commands []*_Command
+}
+
+// Global flag, accepted anywhere on the command line.
+type _GlobalFlag struct {
+	Name    string
+	Value   string
+	Summary string
+	Apply   func(value string) error
+}
+
+// Global flags. The --help and --version flags are handled by RunCommand.
+var globalFlags = []*_GlobalFlag{
+	{"config", "<path>", "configuration file to use (overrides " + ENV_CONFIG + ")", func(value string) error {
+		selectedConfigFile = value
+		return os.Setenv(ENV_CONFIG, value)
+	}},
+	{"profile", "<name>", "configuration profile to use (overrides " + ENV_PROFILE + ")", func(value string) error {
+		selectedProfile = value
+		return ValidateProfileName(value)
+	}},
+	{"log-level", "<level>", "debug, info or error (default: info)", SetLogLevel},
+}
+
+// Names of the roles, as offered by the shell completion.
+var roleChoices = []string{"client", "server"}
+
+// Returns the commands of the program.
+func commandTree() *_Command {
+	ret := &_Command{Name: PROGRAM_NAME, Summary: "Forward (media-)keys to another computer"}
+	ret.Commands = []*_Command{
+		{Name: "client", Summary: "Capture keys and forward them to the target machine", Run: func(args []string) { Run("client") }},
+		{Name: "server", Summary: "Receive keys and emit them on this machine", Run: func(args []string) { Run("server") }},
+		{Name: "configure", Summary: "Change the client or server configuration", Commands: []*_Command{
+			{Name: "client", Summary: "Configure the client, interactively or using flags", HasFlags: true, Run: ConfigureClient},
+			{Name: "server", Summary: "Configure the server, interactively or using flags", HasFlags: true, Run: ConfigureServer},
+		}},
+		{Name: "send", Args: "[-ack] [-timeout <duration>] <key> [<key>...]", MinArgs: 1, HasFlags: true,
+			Summary: "Send keys to the target machine, e.g. from scripts", Run: SendKeys},
+		{Name: "status", Summary: "Show the status of the running client and server", Run: func(args []string) { PrintStatus() }},
+		{Name: "clients", Summary: "Manage the client entries of the server", Commands: []*_Command{
+			{Name: "list", Summary: "List the client entries", Run: action(ManageClients, "list")},
+			{Name: "add", Args: "<name>", MinArgs: 1, Summary: "Add a client entry with its own secret", Run: action(ManageClients, "add")},
+			{Name: "remove", Args: "<name>", MinArgs: 1, Summary: "Remove a client entry", Run: action(ManageClients, "remove")},
+			{Name: "enable", Args: "<name>", MinArgs: 1, Summary: "Enable a client entry", Run: action(ManageClients, "enable")},
+			{Name: "disable", Args: "<name>", MinArgs: 1, Summary: "Disable a client entry", Run: action(ManageClients, "disable")},
+		}},
+		{Name: "secret", Summary: "Manage the key generations of the server", Commands: []*_Command{
+			{Name: "rotate", Args: "[-grace <duration>]", HasFlags: true, Summary: "Create a new key generation", Run: action(ManageSecret, "rotate")},
+			{Name: "list", Summary: "List the secrets and their expiry", Run: action(ManageSecret, "list")},
+		}},
+		{Name: "keys", Summary: "Show the supported keys", Commands: []*_Command{
+			{Name: "list", Args: "[preset]", Summary: "List all keys or the keys of a preset", Run: action(ManageKeys, "list")},
+			{Name: "lookup", Args: "<name|code>", MinArgs: 1, Summary: "Look up a key by name, alias or code", Run: action(ManageKeys, "lookup")},
+		}},
+		{Name: "config", Summary: "Inspect, migrate and transfer the stored configuration", Commands: []*_Command{
+			{Name: "check", Args: "[client|server]", Choices: roleChoices, Summary: "Check the configuration", Run: action(ManageConfig, "check")},
+			{Name: "show", Args: "[client|server]", Choices: roleChoices, Summary: "Show the configuration in effect, with masked secrets", Run: action(ManageConfig, "show")},
+			{Name: "migrate", Args: "[-dry-run] [-secret-store <name>]", HasFlags: true, Summary: "Move the configuration to the configuration file", Run: MigrateConfiguration},
+			{Name: "export", Args: "[-secrets] [-o <file>] [client|server]", HasFlags: true, Choices: roleChoices, Summary: "Write the configuration as portable bundle", Run: ExportConfiguration},
+			{Name: "import", Args: "[-secret-store <name>] [-force] <file>", HasFlags: true, Summary: "Apply a configuration bundle", Run: ImportConfiguration},
+		}},
+		{Name: "profile", Summary: "Manage the configuration profiles", Commands: []*_Command{
+			{Name: "list", Summary: "List the profiles", Run: action(ManageProfiles, "list")},
+			{Name: "copy", Args: "<from> <to>", MinArgs: 2, Summary: "Copy a profile along with its secrets", Run: action(ManageProfiles, "copy")},
+			{Name: "delete", Args: "<name>", MinArgs: 1, Summary: "Remove a profile along with its secrets", Run: action(ManageProfiles, "delete")},
+			{Name: "default", Args: "[name]", Summary: "Show or set the default profile", Run: action(ManageProfiles, "default")},
+		}},
+		{Name: "completion", Args: "<bash|zsh|fish|powershell>", MinArgs: 1, Choices: completionShells(),
+			Summary: "Print the shell completion script", Run: PrintCompletion},
+		{Name: "version", Summary: "Show the version", Run: func(args []string) { fmt.Println(versionString()) }},
+		{Name: "help", Args: "[command...]", Summary: "Show the help of a command", Run: func(args []string) {
+			command, _, err := ret.find(args)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(EXIT_USAGE)
+			}
+			command.printUsage(os.Stdout, strings.Join(append([]string{PROGRAM_NAME}, args...), " "))
+		}},
+	}
+	return ret
+}
+
+// Returns a function running the given action of a Manage* function.
+func action(manage func(args []string), name string) func(args []string) {
+	return func(args []string) {
+		manage(append([]string{name}, args...))
+	}
+}
+
+// Runs the command given by the arguments.
+// Global flags are accepted anywhere (see parseGlobalFlags). Returns the exit code.
+func RunCommand(root *_Command, args []string) int {
+	if len(args) > 0 && args[0] == "__complete" {
+		printCompletion(root, args[1:])
+		return EXIT_SUCCESS
+	}
+	args, showVersion, err := parseGlobalFlags(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", PROGRAM_NAME, err)
+		return EXIT_USAGE
+	}
+	if showVersion {
+		fmt.Println(versionString())
+		return EXIT_SUCCESS
+	}
+
+	command, rest, err := root.find(args)
+	path := strings.Join(append([]string{PROGRAM_NAME}, args[:len(args)-len(rest)]...), " ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n\n", PROGRAM_NAME, err)
+		command.printUsage(os.Stderr, path)
+		return EXIT_USAGE
+	}
+	if len(rest) > 0 && isHelpFlag(rest[0]) && !command.HasFlags {
+		command.printUsage(os.Stdout, path)
+		return EXIT_SUCCESS
+	}
+	// Commands taking flags handle "--" on their own.
+	if len(rest) > 0 && rest[0] == "--" && !command.HasFlags {
+		rest = rest[1:]
+	}
+	if command.Run == nil {
+		fmt.Fprintf(os.Stderr, "%s: missing command\n\n", PROGRAM_NAME)
+		command.printUsage(os.Stderr, path)
+		return EXIT_USAGE
+	}
+	if len(rest) < command.MinArgs {
+		fmt.Fprintf(os.Stderr, "%s: missing arguments\n\n", PROGRAM_NAME)
+		command.printUsage(os.Stderr, path)
+		return EXIT_USAGE
+	}
+	command.Run(rest)
+	return EXIT_SUCCESS
+}
+
+// Returns the command given by the leading arguments and the remaining arguments.
+// Returns the last command found along with an error, if a subcommand is unknown.
+func (t *_Command) find(args []string) (*_Command, []string, error) {
+	command := t
+	for len(args) > 0 && len(command.Commands) > 0 {
+		if isHelpFlag(args[0]) {
+			break
+		}
+		next := command.subcommand(args[0])
+		if next == nil {
+			return command, args, fmt.Errorf("unknown command '%s'", args[0])
+		}
+		command = next
+		args = args[1:]
+	}
+	return command, args, nil
+}
+
+// Returns the subcommand with the given name or nil, if there is none.
+func (t *_Command) subcommand(name string) *_Command {
+	for _, command := range t.Commands {
+		if command.Name == name {
+			return command
+		}
+	}
+	return nil
+}
+
+// Prints the usage of the command. The given path is the command line invoking it.
+func (t *_Command) printUsage(w io.Writer, path string) {
+	if len(t.Commands) > 0 {
+		fmt.Fprintf(w, "Usage: %s <command>\n\n%s.\n\nCommands:\n", path, t.Summary)
+		width := 0
+		for _, command := range t.Commands {
+			width = max(width, len(command.usageName()))
+		}
+		for _, command := range t.Commands {
+			fmt.Fprintf(w, "  %-*s  %s\n", width, command.usageName(), command.Summary)
+		}
+	} else {
+		fmt.Fprintf(w, "Usage: %s %s\n\n%s.\n", path, t.Args, t.Summary)
+		if t.HasFlags {
+			fmt.Fprintf(w, "Use '%s -h' to list the flags of the command.\n", path)
+		}
+	}
+	fmt.Fprintln(w, "\nGlobal flags:")
+	for _, flag := range globalFlags {
+		fmt.Fprintf(w, "  --%-20s %s\n", flag.Name+" "+flag.Value, flag.Summary)
+	}
+	fmt.Fprintf(w, "  --%-20s %s\n", "help, -h", "show the help")
+	fmt.Fprintf(w, "  --%-20s %s\n", "version", "show the version")
+	if len(t.Commands) > 0 {
+		fmt.Fprintf(w, "\nUse '%s <command> --help' for the help of a command.\n", path)
+	}
+}
+
+// Returns the name of the command along with its arguments.
+func (t *_Command) usageName() string {
+	if len(t.Commands) > 0 {
+		return t.Name + " <command>"
+	}
+	return strings.TrimSpace(t.Name + " " + t.Args)
+}
+
+// Returns true, if the given argument asks for help.
+func isHelpFlag(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+// Applies and removes the global flags from the given arguments.
+// The flags may be given anywhere before "--", as "--name value", "--name=value" or with a
+// single dash. --version is only recognized before the first command word, so it can still
+// be passed to commands as an argument.
+// Returns the remaining arguments and whether --version was given.
+func parseGlobalFlags(args []string) ([]string, bool, error) {
+	ret := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			ret = append(ret, args[i:]...)
+			break
+		}
+		if len(ret) == 0 && (args[i] == "--version" || args[i] == "-version") {
+			return nil, true, nil
+		}
+		flag, value, hasValue := findGlobalFlag(args[i])
+		if flag == nil {
+			ret = append(ret, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, false, fmt.Errorf("missing value of --%s", flag.Name)
+			}
+			value = args[i+1]
+			i++
+		}
+		if err := flag.Apply(value); err != nil {
+			return nil, false, err
+		}
+	}
+	return ret, false, nil
+}
+
+// Returns the global flag given by the argument, along with its value, if the argument
+// contains it.
+func findGlobalFlag(arg string) (*_GlobalFlag, string, bool) {
+	name := strings.TrimLeft(arg, "-")
+	if name == arg || len(arg)-len(name) > 2 {
+		return nil, "", false
+	}
+	name, value, hasValue := strings.Cut(name, "=")
+	for _, flag := range globalFlags {
+		if flag.Name == name {
+			return flag, value, hasValue
+		}
+	}
+	return nil, "", false
+}
+
+// Returns the version of the program, along with the Go version and platform.
+func versionString() string {
+	ret := version
+	if info, ok := debug.ReadBuildInfo(); ok && ret == "dev" {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+				ret += "+" + setting.Value[:12]
+			}
+		}
+	}
+	return fmt.Sprintf("%s %s (%s, %s/%s)", PROGRAM_NAME, ret, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// Shell completion scripts, by shell.
+// The scripts call the hidden command "__complete", which prints the candidates for the
+// last of the given words.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for keyfwd
+# Load using: source <(keyfwd completion bash)
+_keyfwd() {
+	local IFS=$'\n'
+	COMPREPLY=($("${COMP_WORDS[0]}" __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -F _keyfwd keyfwd keyfwd.exe
+`,
+	"zsh": `#compdef keyfwd
+# zsh completion for keyfwd
+# Load using: source <(keyfwd completion zsh)
+_keyfwd() {
+	local -a candidates
+	candidates=(${(f)"$("${words[1]}" __complete "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+	compadd -a candidates
+}
+compdef _keyfwd keyfwd
+`,
+	"fish": `# fish completion for keyfwd
+# Load using: keyfwd completion fish | source
+complete -c keyfwd -f -a '(keyfwd __complete (commandline -opc)[2..-1] (commandline -ct))'
+`,
+	"powershell": `# PowerShell completion for keyfwd
+# Load using: keyfwd.exe completion powershell | Out-String | Invoke-Expression
+Register-ArgumentCompleter -Native -CommandName keyfwd, keyfwd.exe -ScriptBlock {
+	param($wordToComplete, $commandAst, $cursorPosition)
+	$words = @($commandAst.CommandElements | Select-Object -Skip 1 | ForEach-Object { $_.ToString() })
+	if ($wordToComplete -eq '') { $words += '""' }
+	& $commandAst.CommandElements[0].ToString() __complete @words | ForEach-Object {
+		[System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+	}
+}
+`,
+}
+
+// Returns the shells supported by the completion.
+func completionShells() []string {
+	ret := make([]string, 0, len(completionScripts))
+	for shell := range completionScripts {
+		ret = append(ret, shell)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Prints the completion script of the given shell.
+func PrintCompletion(args []string) {
+	script, ok := completionScripts[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "%s: unsupported shell '%s' (%s)\n", PROGRAM_NAME, args[0], strings.Join(completionShells(), ", "))
+		os.Exit(EXIT_USAGE)
+	}
+	fmt.Print(script)
+}
+
+// Returns the completion candidates for the last of the given words.
+func completeWords(root *_Command, words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	prefix := strings.Trim(words[len(words)-1], `"`)
+	words = words[:len(words)-1]
+
+	candidates := make([]string, 0)
+	if len(words) > 0 {
+		if flag, _, hasValue := findGlobalFlag(words[len(words)-1]); flag != nil && !hasValue {
+			switch flag.Name {
+			case "profile":
+				candidates = OpenConfigStore(DEFAULT_PROFILE).Profiles()
+			case "log-level":
+				candidates = []string{LOG_LEVEL_DEBUG, LOG_LEVEL_INFO, LOG_LEVEL_ERROR}
+			}
+			return filterPrefix(candidates, prefix)
+		}
+	}
+	if strings.HasPrefix(prefix, "-") {
+		for _, flag := range globalFlags {
+			candidates = append(candidates, "--"+flag.Name)
+		}
+		return filterPrefix(append(candidates, "--help", "--version"), prefix)
+	}
+
+	args, _, err := parseGlobalFlags(words)
+	if err != nil {
+		return nil
+	}
+	command, rest, err := root.find(args)
+	if err != nil {
+		return nil
+	}
+	if command.Name == "help" && command.Run != nil {
+		if command, _, err = root.find(rest); err != nil {
+			return nil
+		}
+		rest = nil
+	}
+	if len(command.Commands) > 0 {
+		for _, subcommand := range command.Commands {
+			candidates = append(candidates, subcommand.Name)
+		}
+	} else if len(rest) == 0 {
+		candidates = command.Choices
+	}
+	return filterPrefix(candidates, prefix)
+}
+
+// Returns the given candidates starting with the given prefix.
+func filterPrefix(candidates []string, prefix string) []string {
+	ret := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			ret = append(ret, candidate)
+		}
+	}
+	return ret
+}
+
+// Prints the completion candidates for the hidden command "__complete".
+func printCompletion(root *_Command, words []string) {
+	for _, candidate := range completeWords(root, words) {
+		fmt.Println(candidate)
+	}
+}
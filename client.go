@@ -1,10 +1,17 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
-	"log"
+	"context"
+	"errors"
 	"net"
+	"os"
+	"time"
+)
+
+// Names of the client's status counters.
+const (
+	COUNTER_SENT        = "sent"
+	COUNTER_SEND_FAILED = "send_failed"
 )
 
 type _Client struct {
@@ -12,57 +19,226 @@ type _Client struct {
 	encryption      Encryption
 	connection      *net.UDPConn
 	configuration   *ClientConfiguration
+	counters        *_Counters
+	started         time.Time
+	reloads         chan *_ClientReload
+	stopped         chan bool
+}
+
+// Request to apply a new configuration to the running client.
+type _ClientReload struct {
+	configuration *ClientConfiguration
+	result        chan error
 }
 
 func NewClient(config *ClientConfiguration) *_Client {
 	ret := new(_Client)
-	ret.keyboardCapture = NewKeyboardCapture(config.ForwardedKeys)
+	ret.counters = NewCounters()
+	ret.keyboardCapture = NewKeyboardCapture(config.ForwardedKeys, NewEventQueue("capture", config.Queue, ret.counters))
 	ret.configuration = config
+	ret.reloads = make(chan *_ClientReload)
+	ret.stopped = make(chan bool)
 	return ret
 }
 
 // Starts the client.
 // The function starts intercepting keys. A configurable set of keys cause an encrypted JSON
-// message to be sent to the configured remote host via UDP.
-// The function blocks until the Client.Stop() function was called.
+// message to be sent to the configured remote host via UDP. Heartbeats and cover traffic are
+// sent in between, if configured.
+// The function blocks until the given context is cancelled or the key interception stops.
+// Keys, which were already intercepted, are still sent before the function returns.
 // Returns an error in case the keyboard interception initialization or UDP client initialization failed.
-func (t *_Client) Start() error {
-	quit := make(chan bool)
+func (t *_Client) Start(ctx context.Context) error {
+	defer close(t.stopped)
+	var err error
+	t.connection, err = dialRemote(t.configuration)
+	if err != nil {
+		return err
+	}
+	defer func() { t.connection.Close() }()
+	t.encryption.Initialize(t.configuration.Secret)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	t.started = time.Now()
+	go ReportStatus(ctx, t.Status)
+	captureResult := make(chan error, 1)
 	go func() {
-		addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", t.configuration.Hostname, t.configuration.Port))
-		if err != nil {
-			log.Fatal(err)
-		}
-		t.connection, err = net.DialUDP("udp", nil, addr)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer t.connection.Close()
+		logInfo("Starting keyboard interception")
+		captureResult <- t.keyboardCapture.SyncReceive()
+		cancel()
+	}()
 
-		t.encryption.Initialize(t.configuration.Secret)
-		for {
+	heartbeat, cover := t.newTrafficTickers()
+	defer func() {
+		heartbeat.Stop()
+		cover.Stop()
+	}()
+	keys := t.immediateKeys()
+	for {
+		select {
+		case k := <-keys:
+			t.sendKey(k.(Key))
+		case <-heartbeat.C:
+			logDebug("Sending heartbeat to remote host")
+			t.send(Message{Type: MESSAGE_HEARTBEAT})
+		case <-cover.C:
+			// A pending key takes the slot of the cover packet.
 			select {
-			case k := <-t.keyboardCapture.KeyPressed:
-				msg := Message{k}
-				jsonData, _ := json.Marshal(msg)
-				log.Printf("Sending key %d to remote host\n", k)
-				t.connection.Write(t.encryption.Encrypt([]byte(jsonData)))
-			case <-quit:
-				return
+			case k := <-t.keyboardCapture.KeyPressed.Events():
+				t.sendKey(k.(Key))
+			default:
+				t.send(Message{Type: MESSAGE_COVER})
+			}
+		case request := <-t.reloads:
+			err := t.apply(request.configuration)
+			if err == nil {
+				heartbeat.Stop()
+				cover.Stop()
+				heartbeat, cover = t.newTrafficTickers()
+				keys = t.immediateKeys()
 			}
+			request.result <- err
+		case <-ctx.Done():
+			logInfo("Stopping keyboard interception")
+			t.keyboardCapture.Stop()
+			err := <-captureResult
+			t.flush()
+			if err := WriteStatus(t.Status()); err != nil {
+				logError("Unable to write status file: %s", err)
+			}
+			return err
 		}
-	}()
+	}
+}
+
+// Applies the given configuration to the running client.
+// The new target host is dialed and the encryption is re-keyed. Keys, which were
+// intercepted but not sent yet, are sent using the new configuration. The queue settings
+// take effect after a restart.
+// Returns an error, if the new target host can't be reached. The current configuration is
+// kept then.
+func (t *_Client) Reload(config *ClientConfiguration) error {
+	request := &_ClientReload{configuration: config, result: make(chan error, 1)}
+	select {
+	case t.reloads <- request:
+		return <-request.result
+	case <-t.stopped:
+		return errors.New("the client is stopped")
+	}
+}
+
+// Applies the given configuration. Called by the send loop of Start, so sending never
+// sees a partly applied configuration.
+func (t *_Client) apply(config *ClientConfiguration) error {
+	connection, err := dialRemote(config)
+	if err != nil {
+		return err
+	}
+	t.connection.Close()
+	t.connection = connection
+	t.encryption.Initialize(config.Secret)
+	t.keyboardCapture.SetForwardedKeys(config.ForwardedKeys)
+	if config.Queue != t.configuration.Queue {
+		logInfo("The new queue settings take effect after a restart")
+	}
+	t.configuration = config
+	logInfo("Configuration reloaded, sending to %s", connection.RemoteAddr())
+	return nil
+}
+
+// Returns the tickers of the heartbeats and the cover traffic.
+func (t *_Client) newTrafficTickers() (*time.Ticker, *time.Ticker) {
+	return newTicker(time.Duration(t.configuration.Traffic.HeartbeatSeconds) * time.Second),
+		newTicker(time.Duration(t.configuration.Traffic.CoverMilliseconds) * time.Millisecond)
+}
+
+// Returns the channel of the keys to send at once. Using cover traffic, keys are sent in
+// place of the next cover packet instead, so packets leave at a constant rate and the
+// timing doesn't reveal the key presses. The returned channel is nil then.
+func (t *_Client) immediateKeys() <-chan interface{} {
+	if t.configuration.Traffic.CoverMilliseconds > 0 {
+		return nil
+	}
+	return t.keyboardCapture.KeyPressed.Events()
+}
+
+// Returns a UDP connection to the remote host of the given configuration.
+func dialRemote(config *ClientConfiguration) (*net.UDPConn, error) {
+	addr, err := ResolveRemoteAddress(config.Hostname, config.Port)
+	if err != nil {
+		return nil, err
+	}
+	source, err := ResolveSourceAddress(config.SourceAddress, addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialUDP("udp", source, addr)
+}
+
+// Sends the keys, which were intercepted but not sent yet.
+func (t *_Client) flush() {
+	for {
+		select {
+		case k := <-t.keyboardCapture.KeyPressed.Events():
+			t.sendKey(k.(Key))
+		default:
+			return
+		}
+	}
+}
+
+// Sends the given key to the remote host.
+func (t *_Client) sendKey(key Key) {
+	logInfo("Sending key %s to remote host", key)
+	t.send(keyMessage(key))
+}
 
-	log.Println("Starting keyboard interception")
-	err := t.keyboardCapture.SyncReceive()
-	quit <- true
+// Returns the message of the given key.
+func keyMessage(key Key) Message {
+	ret := Message{Key: string(key)}
+	ret.VkCode, _ = key.Windows()
+	return ret
+}
+
+// Encrypts the given message and sends it to the remote host.
+// Returns an error in case sending failed.
+func (t *_Client) send(msg Message) error {
+	msg.Device = t.configuration.DeviceName
+	data, err := EncodeMessage(msg, t.configuration.Traffic.PacketSize)
+	if err != nil {
+		logError("Unable to send to remote host: %s (increase Traffic.PacketSize)", err)
+		t.counters.Inc(COUNTER_SEND_FAILED)
+		return err
+	}
+	_, err = t.connection.Write(EncodePacket(t.configuration.KeyID, t.encryption.Encrypt(data)))
+	if err != nil {
+		logDebug("Unable to send to remote host: %s", err)
+		t.counters.Inc(COUNTER_SEND_FAILED)
+		return err
+	}
+	t.counters.Inc(COUNTER_SENT)
+	return nil
+}
 
-	return err
+// Returns the current status of the client.
+func (t *_Client) Status() *Status {
+	return &Status{
+		Role:     "client",
+		Pid:      os.Getpid(),
+		Started:  t.started,
+		Updated:  time.Now(),
+		Counters: t.counters.Snapshot(),
+	}
 }
 
-// Stops the key-press interception and causes the Client.Start() function to return.
-// Intended to be called from another 'thread' (goroutine) as Client.Start().
-func (t *_Client) Stop() {
-	log.Println("Stopping keyboard interception")
-	t.keyboardCapture.Stop()
+// Returns a ticker firing at the given interval.
+// The ticker never fires, if the interval is zero.
+func newTicker(interval time.Duration) *time.Ticker {
+	if interval <= 0 {
+		ret := time.NewTicker(time.Hour)
+		ret.Stop()
+		return ret
+	}
+	return time.NewTicker(interval)
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"github.com/howeyc/gopass"
+	"log"
+)
+
+// Manages the client entries of the server configuration.
+// Supported actions: list, add <name>, remove <name>, enable <name>, disable <name>
+func ManageClients(args []string) {
+	if len(args) < 1 {
+		log.Fatal("Missing argument")
+	}
+	configuration := loadServerConfigurationForUpdate()
+
+	if args[0] == "list" {
+		for _, client := range configuration.Clients {
+			state := "enabled"
+			if !client.Enabled {
+				state = "disabled"
+			}
+			keys := "server default"
+			if client.AllowedKeys != nil {
+				keys = FormatKeys(client.AllowedKeys)
+			}
+			fmt.Printf("%-20s %-10s keys: %s\n", client.Name, state, keys)
+		}
+		return
+	}
+
+	if len(args) < 2 {
+		log.Fatal("Missing client name")
+	}
+	name := args[1]
+	if len(name) == 0 || len(name) > PACKET_MAX_KEY_ID {
+		log.Fatal("Invalid client name")
+	}
+	client := configuration.FindClient(name)
+
+	switch args[0] {
+	case "add":
+		if client != nil {
+			log.Fatalf("Client '%s' already exists", name)
+		}
+		if findKeyGeneration(configuration, name) != nil {
+			log.Fatalf("Key ID '%s' is used by a key generation", name)
+		}
+		fmt.Printf("%-10s: ", "Password")
+		secret := gopass.GetPasswdMasked()
+		configuration.Clients = append(configuration.Clients, ClientEntry{Name: name, Secret: secret, Enabled: true})
+	case "remove":
+		if client == nil {
+			log.Fatalf("Unknown client '%s'", name)
+		}
+		clients := make([]ClientEntry, 0, len(configuration.Clients))
+		for _, c := range configuration.Clients {
+			if c.Name != name {
+				clients = append(clients, c)
+			}
+		}
+		configuration.Clients = clients
+		if err := DeleteServerClientSecret(configuration, name); err != nil {
+			logError("Unable to remove the secret of client '%s': %s", name, err)
+		}
+	case "enable", "disable":
+		if client == nil {
+			log.Fatalf("Unknown client '%s'", name)
+		}
+		client.Enabled = args[0] == "enable"
+	default:
+		log.Fatal("Unknown clients action")
+	}
+
+	if err := StoreServerConfiguration(configuration); err != nil {
+		log.Fatal(err)
+	}
+}
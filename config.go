@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+)
+
+// Text replacing secrets in the output.
+const MASKED_SECRET = "********"
+
+// Inspection, migration and transfer of the stored configuration.
+// Supported actions: check [client|server], show [client|server],
+// migrate [-dry-run] [-secret-store name], export [-secrets] [-o file] [client|server],
+// import [-secret-store name] [-force] <file>
+func ManageConfig(args []string) {
+	if len(args) < 1 {
+		log.Fatal("Missing argument")
+	}
+
+	switch args[0] {
+	case "check":
+		roles := configuredRoles(args[1:])
+		if len(roles) == 0 {
+			fmt.Println("No configuration found")
+			os.Exit(1)
+		}
+		valid := true
+		for _, role := range roles {
+			valid = CheckConfiguration(role) && valid
+		}
+		if !valid {
+			os.Exit(1)
+		}
+	case "show":
+		roles := configuredRoles(args[1:])
+		if len(roles) == 0 {
+			fmt.Println("No configuration found")
+			os.Exit(1)
+		}
+		ShowConfiguration(roles)
+	case "migrate":
+		MigrateConfiguration(args[1:])
+	case "export":
+		ExportConfiguration(args[1:])
+	case "import":
+		ImportConfiguration(args[1:])
+	default:
+		log.Fatal("Unknown config action")
+	}
+}
+
+// Loads the configuration of the given role and prints every problem found.
+// Returns true, if the configuration is valid.
+func CheckConfiguration(role string) bool {
+	var err error
+	switch role {
+	case "client":
+		_, _, err = LoadEffectiveClientConfiguration(GetConfigStore())
+	case "server":
+		_, _, err = LoadEffectiveServerConfiguration(GetConfigStore())
+	default:
+		log.Fatal(fmt.Sprintf("Unknown role '%s'", role))
+	}
+
+	if err == nil {
+		fmt.Printf("%s configuration: OK\n", role)
+		return true
+	}
+	var problems *ConfigurationError
+	if !errors.As(err, &problems) {
+		fmt.Printf("%s configuration: %s\n", role, err)
+		return false
+	}
+	fmt.Printf("%s configuration: %d problem(s)\n", role, len(problems.Problems))
+	for _, problem := range problems.Problems {
+		fmt.Printf("  %-30s %s\n", problem.Field, problem.Err)
+	}
+	return false
+}
+
+// Returns the given roles or, if none are given, the roles having a stored configuration.
+func configuredRoles(roles []string) []string {
+	if len(roles) > 0 {
+		return roles
+	}
+	if ClientConfigurationExists() {
+		roles = append(roles, "client")
+	}
+	if ServerConfigurationExists() {
+		roles = append(roles, "server")
+	}
+	return roles
+}
+
+// Value of the effective configuration, along with its origin.
+type _ShownValue struct {
+	Field  string
+	Origin string
+	Value  string
+}
+
+// Prints the effective configuration of the given roles. Secrets are masked.
+// Each value is shown along with its origin: the configuration storage ("file" or
+// "registry"), the secret storage, a flag, an environment variable (see clientOverrides
+// and serverOverrides) or the default.
+func ShowConfiguration(roles []string) {
+	profile, profileOrigin := currentProfileOrigin()
+	store := OpenConfigStore(profile)
+	locationOrigin := "default"
+	if store.Kind() == "file" && len(selectedConfigFile) > 0 {
+		locationOrigin = "flag --config"
+	} else if store.Kind() == "file" && len(os.Getenv(ENV_CONFIG)) > 0 {
+		locationOrigin = "env " + ENV_CONFIG
+	}
+	printShownValues([]_ShownValue{
+		{"Profile", profileOrigin, profile},
+		{"Location", locationOrigin, store.Location()},
+	})
+
+	for _, role := range roles {
+		var values []_ShownValue
+		var err error
+		switch role {
+		case "client":
+			var configuration *ClientConfiguration
+			var overridden map[string]string
+			configuration, overridden, err = LoadEffectiveClientConfiguration(store)
+			values = shownValues(configuration, store.StoredClientFields(), store.Kind(), overridden)
+			// An empty device name is replaced by the host name when loading.
+			hostname, _ := os.Hostname()
+			for i := range values {
+				if values[i].Field == "DeviceName" && values[i].Origin == "default" && configuration.DeviceName == hostname {
+					values[i].Origin = "default (host name)"
+				}
+			}
+			origin := "secret store " + normalizeSecretStore(configuration.SecretStore)
+			values = append(values, _ShownValue{"Secret", origin, maskSecret(configuration.Secret)})
+		case "server":
+			var configuration *ServerConfiguration
+			var overridden map[string]string
+			configuration, overridden, err = LoadEffectiveServerConfiguration(store)
+			values = shownValues(configuration, store.StoredServerFields(), store.Kind(), overridden)
+			origin := "secret store " + normalizeSecretStore(configuration.SecretStore)
+			values = append(values, _ShownValue{"Secret", origin, maskSecret(configuration.Secret)})
+			for _, client := range configuration.Clients {
+				values = append(values, _ShownValue{fmt.Sprintf("Clients[%s].Secret", client.Name), origin, maskSecret(client.Secret)})
+			}
+			for _, generation := range configuration.KeyGenerations {
+				values = append(values, _ShownValue{fmt.Sprintf("KeyGenerations[%s].Secret", generation.ID), origin, maskSecret(generation.Secret)})
+			}
+		default:
+			log.Fatal(fmt.Sprintf("Unknown role '%s'", role))
+		}
+		if errors.Is(err, ErrConfigurationUnreadable) {
+			log.Fatal(err)
+		}
+		fmt.Printf("\n%s configuration:\n", role)
+		printShownValues(values)
+		var problems *ConfigurationError
+		if errors.As(err, &problems) {
+			fmt.Printf("%d problem(s), see 'keyfwd config check %s'\n", len(problems.Problems), role)
+		}
+	}
+}
+
+// Returns the values of all fields of the given configuration.
+// Overridden fields originate from the environment variable they are mapped to. Fields,
+// which are stored, originate from the storage of the given kind. The other fields have
+// their default values.
+func shownValues(configuration interface{}, stored []string, kind string, overridden map[string]string) []_ShownValue {
+	isStored := make(map[string]bool, len(stored))
+	for _, name := range stored {
+		isStored[name] = true
+	}
+	value := reflect.Indirect(reflect.ValueOf(configuration))
+	ret := make([]_ShownValue, 0)
+	for _, name := range configurationFields(configuration) {
+		data, _ := json.Marshal(value.FieldByName(name).Interface())
+		origin := "default"
+		if variable, ok := overridden[name]; ok {
+			origin = "env " + variable
+		} else if isStored[name] {
+			origin = kind
+		}
+		ret = append(ret, _ShownValue{name, origin, string(data)})
+	}
+	return ret
+}
+
+// Returns the names of the stored fields of the given configuration: the exported fields,
+// which are not excluded from JSON.
+func configurationFields(configuration interface{}) []string {
+	structType := reflect.Indirect(reflect.ValueOf(configuration)).Type()
+	ret := make([]string, 0, structType.NumField())
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.IsExported() && field.Tag.Get("json") != "-" {
+			ret = append(ret, field.Name)
+		}
+	}
+	return ret
+}
+
+// Returns the given secret masked.
+func maskSecret(secret []byte) string {
+	if len(secret) == 0 {
+		return "(not set)"
+	}
+	return MASKED_SECRET
+}
+
+// Prints the given values as table.
+func printShownValues(values []_ShownValue) {
+	fieldWidth, originWidth := 0, 0
+	for _, value := range values {
+		fieldWidth = max(fieldWidth, len(value.Field))
+		originWidth = max(originWidth, len(value.Origin)+2)
+	}
+	for _, value := range values {
+		fmt.Printf("  %-*s  %-*s  %s\n", fieldWidth, value.Field, originWidth, "["+value.Origin+"]", value.Value)
+	}
+}
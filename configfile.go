@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Version of the configuration file format written by this program.
+// Files with a newer version are rejected, as they may contain settings this program
+// does not know about.
+const CONFIG_SCHEMA_VERSION = 2
+
+// Contents of the configuration file: the configurations of all profiles.
+type _ConfigFile struct {
+	SchemaVersion  int
+	DefaultProfile string `json:",omitempty"`
+	Profiles       map[string]*_ConfigProfile
+}
+
+// Configuration of a profile.
+// The sections are kept as raw JSON, so that storing one role's configuration doesn't
+// touch the other one.
+type _ConfigProfile struct {
+	Client json.RawMessage `json:",omitempty"`
+	Server json.RawMessage `json:",omitempty"`
+}
+
+// Returns the configuration of the given profile, which is created if necessary.
+func (t *_ConfigFile) profile(name string) *_ConfigProfile {
+	if t.Profiles == nil {
+		t.Profiles = make(map[string]*_ConfigProfile)
+	}
+	if t.Profiles[name] == nil {
+		t.Profiles[name] = new(_ConfigProfile)
+	}
+	return t.Profiles[name]
+}
+
+// Configuration storage of a profile in a JSON file.
+// Secrets are kept in the configured secret storage, not inside the file. They are stored
+// first, so that a failing secret storage leaves the file untouched.
+type _FileStore struct {
+	path    string
+	profile string
+}
+
+// Returns a configuration storage using the given profile of the JSON file at the given path.
+func NewFileStore(path string, profile string) ConfigStore {
+	ret := new(_FileStore)
+	ret.path = path
+	ret.profile = profile
+	return ret
+}
+
+func (t *_FileStore) Location() string {
+	return t.path
+}
+
+func (t *_FileStore) Kind() string {
+	return "file"
+}
+
+func (t *_FileStore) Profile() string {
+	return t.profile
+}
+
+// Changes with the configuration file and the secret file next to it.
+func (t *_FileStore) Revision() string {
+	return fileRevision(t.path) + "," + fileRevision(DefaultSecretFilePath())
+}
+
+func (t *_FileStore) Profiles() []string {
+	file, err := t.read()
+	if err != nil {
+		return nil
+	}
+	ret := make([]string, 0, len(file.Profiles))
+	for name, profile := range file.Profiles {
+		if len(profile.Client) > 0 || len(profile.Server) > 0 {
+			ret = append(ret, name)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (t *_FileStore) DefaultProfile() string {
+	file, err := t.read()
+	if err != nil {
+		return ""
+	}
+	return file.DefaultProfile
+}
+
+func (t *_FileStore) SetDefaultProfile(name string) error {
+	file, err := t.read()
+	if err != nil {
+		return err
+	}
+	file.DefaultProfile = name
+	return t.write(file)
+}
+
+func (t *_FileStore) DeleteProfile() error {
+	file, err := t.read()
+	if err != nil {
+		return err
+	}
+	delete(file.Profiles, t.profile)
+	if file.DefaultProfile == t.profile {
+		file.DefaultProfile = ""
+	}
+	return t.write(file)
+}
+
+func (t *_FileStore) ClientConfigurationExists() bool {
+	file, err := t.read()
+	return err == nil && file.Profiles[t.profile] != nil && len(file.Profiles[t.profile].Client) > 0
+}
+
+func (t *_FileStore) ServerConfigurationExists() bool {
+	file, err := t.read()
+	return err == nil && file.Profiles[t.profile] != nil && len(file.Profiles[t.profile].Server) > 0
+}
+
+func (t *_FileStore) StoredClientFields() []string {
+	file, err := t.read()
+	if err != nil {
+		return nil
+	}
+	return sectionFields(file.profile(t.profile).Client)
+}
+
+func (t *_FileStore) StoredServerFields() []string {
+	file, err := t.read()
+	if err != nil {
+		return nil
+	}
+	return sectionFields(file.profile(t.profile).Server)
+}
+
+// Returns the names of the members of the given configuration section.
+func sectionFields(section json.RawMessage) []string {
+	var members map[string]json.RawMessage
+	if json.Unmarshal(section, &members) != nil {
+		return nil
+	}
+	ret := make([]string, 0, len(members))
+	for name := range members {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (t *_FileStore) LoadClientConfiguration() (*ClientConfiguration, error) {
+	problems := &ConfigurationError{Role: "client"}
+	ret := NewClientConfiguration()
+	ret.Profile = t.profile
+	file, err := t.read()
+	if err != nil {
+		problems.Add(t.path, err)
+	} else if section := file.profile(t.profile).Client; len(section) > 0 {
+		if err := json.Unmarshal(section, ret); err != nil {
+			problems.Add("Client", fmt.Errorf("%w: %s", ErrConfigurationUnreadable, err))
+		}
+	}
+	if len(ret.DeviceName) == 0 {
+		ret.DeviceName, _ = os.Hostname()
+	}
+	problems.Add("SecretStore", loadClientSecret(ret))
+	ret.validate(problems)
+	return ret, problems.Err()
+}
+
+func (t *_FileStore) LoadServerConfiguration() (*ServerConfiguration, error) {
+	problems := &ConfigurationError{Role: "server"}
+	ret := NewServerConfiguration()
+	ret.Profile = t.profile
+	file, err := t.read()
+	if err != nil {
+		problems.Add(t.path, err)
+	} else if section := file.profile(t.profile).Server; len(section) > 0 {
+		if err := json.Unmarshal(section, ret); err != nil {
+			problems.Add("Server", fmt.Errorf("%w: %s", ErrConfigurationUnreadable, err))
+		}
+	}
+	problems.Add("SecretStore", loadServerSecrets(ret))
+	ret.validate(problems)
+	return ret, problems.Err()
+}
+
+func (t *_FileStore) StoreClientConfiguration(configuration *ClientConfiguration) error {
+	file, err := t.read()
+	if err != nil {
+		return err
+	}
+	configuration.Profile = t.profile
+	if err := storeClientSecret(configuration); err != nil {
+		return err
+	}
+	if file.profile(t.profile).Client, err = json.Marshal(configuration); err != nil {
+		return err
+	}
+	return t.write(file)
+}
+
+func (t *_FileStore) StoreServerConfiguration(configuration *ServerConfiguration) error {
+	file, err := t.read()
+	if err != nil {
+		return err
+	}
+	configuration.Profile = t.profile
+	if err := storeServerSecrets(configuration); err != nil {
+		return err
+	}
+	if file.profile(t.profile).Server, err = json.Marshal(configuration); err != nil {
+		return err
+	}
+	return t.write(file)
+}
+
+// Reads the configuration file.
+// Files of older schema versions are upgraded (see migrateConfigDocument); the upgrade is
+// saved with the next change. A missing file is treated as an empty configuration.
+func (t *_FileStore) read() (*_ConfigFile, error) {
+	ret := &_ConfigFile{SchemaVersion: CONFIG_SCHEMA_VERSION}
+	if len(t.path) == 0 {
+		return nil, fmt.Errorf("no configuration file (set %s)", ENV_CONFIG)
+	}
+	data, err := os.ReadFile(t.path)
+	if os.IsNotExist(err) {
+		return ret, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrConfigurationUnreadable, err)
+	}
+	if _, err := migrateConfigDocument(document); err != nil {
+		return nil, err
+	}
+	if raw, ok := document["DefaultProfile"]; ok {
+		if err := json.Unmarshal(raw, &ret.DefaultProfile); err != nil {
+			return nil, fmt.Errorf("%w: DefaultProfile: %s", ErrConfigurationUnreadable, err)
+		}
+	}
+	if raw, ok := document["Profiles"]; ok {
+		if err := json.Unmarshal(raw, &ret.Profiles); err != nil {
+			return nil, fmt.Errorf("%w: Profiles: %s", ErrConfigurationUnreadable, err)
+		}
+	}
+	return ret, nil
+}
+
+// Writes the configuration file.
+// The file is replaced atomically and is readable by the current user only.
+func (t *_FileStore) write(file *_ConfigFile) error {
+	data, err := renderConfigFile(file)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(t.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".config-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), t.path)
+}
+
+// Returns the contents of the configuration file in the current schema version.
+func renderConfigFile(file *_ConfigFile) ([]byte, error) {
+	file.SchemaVersion = CONFIG_SCHEMA_VERSION
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
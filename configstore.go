@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Environment variable selecting the configuration file to use.
+const ENV_CONFIG = "KEYFWD_CONFIG"
+
+// Configuration file selected by the --config flag, see parseGlobalFlags.
+var selectedConfigFile string
+
+// Storage of the client and server configuration of a profile.
+// Secrets are not part of the configuration storage, but kept in the configured secret
+// storage (see SecretStore).
+type ConfigStore interface {
+	// Returns a description of the storage location for messages.
+	Location() string
+	// Returns the kind of storage: "file" or "registry".
+	Kind() string
+	// Returns the profile, the storage is bound to.
+	Profile() string
+	// Returns a value, which changes whenever the stored configuration changes.
+	Revision() string
+	// Returns the names of all profiles within the storage.
+	Profiles() []string
+	// Returns the stored default profile, or an empty string.
+	DefaultProfile() string
+	SetDefaultProfile(name string) error
+	// Removes the configuration of the profile, the storage is bound to.
+	DeleteProfile() error
+	ClientConfigurationExists() bool
+	ServerConfigurationExists() bool
+	// Returns the names of the fields, which are stored. Other fields have their default values.
+	StoredClientFields() []string
+	StoredServerFields() []string
+	// Returns the loaded configuration and a ConfigurationError listing every invalid field.
+	LoadClientConfiguration() (*ClientConfiguration, error)
+	LoadServerConfiguration() (*ServerConfiguration, error)
+	StoreClientConfiguration(configuration *ClientConfiguration) error
+	StoreServerConfiguration(configuration *ServerConfiguration) error
+}
+
+// Returns the path of the configuration file, if no other file was selected:
+// "keyfwd/config.json" below the user's configuration directory (e.g. $XDG_CONFIG_HOME,
+// ~/.config or %AppData%).
+func DefaultConfigFilePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "keyfwd", "config.json"), nil
+}
+
+// Returns the configuration storage of the current profile (see CurrentProfile).
+func GetConfigStore() ConfigStore {
+	return OpenConfigStore(CurrentProfile())
+}
+
+// Returns the configuration storage of the given profile.
+// The file given by the KEYFWD_CONFIG environment variable takes precedence. Otherwise,
+// the default configuration file is used, if it exists. Without a configuration file, the
+// platform's default storage is used (the registry on Windows, the default configuration
+// file elsewhere).
+func OpenConfigStore(profile string) ConfigStore {
+	path, err := ConfigFilePath()
+	if err == nil {
+		if _, err := os.Stat(path); err == nil || len(os.Getenv(ENV_CONFIG)) > 0 {
+			return NewFileStore(path, profile)
+		}
+	}
+	return defaultConfigStore(path, profile)
+}
+
+// Returns the path of the configuration file: the file given by the KEYFWD_CONFIG
+// environment variable or the default configuration file.
+func ConfigFilePath() (string, error) {
+	if path := os.Getenv(ENV_CONFIG); len(path) > 0 {
+		return path, nil
+	}
+	return DefaultConfigFilePath()
+}
+
+// Returns true, if a client configuration was stored before.
+func ClientConfigurationExists() bool {
+	return GetConfigStore().ClientConfigurationExists()
+}
+
+// Returns true, if a server configuration was stored before.
+func ServerConfigurationExists() bool {
+	return GetConfigStore().ServerConfigurationExists()
+}
+
+// Loads the client configuration from the configuration storage and the secret from the
+// configured secret storage.
+// Returns a ConfigurationError listing every invalid field along with the configuration.
+func LoadClientConfiguration() (*ClientConfiguration, error) {
+	return GetConfigStore().LoadClientConfiguration()
+}
+
+// Loads the server configuration from the configuration storage and the secrets from the
+// configured secret storage.
+// Returns a ConfigurationError listing every invalid field along with the configuration.
+func LoadServerConfiguration() (*ServerConfiguration, error) {
+	return GetConfigStore().LoadServerConfiguration()
+}
+
+// Saves the given client configuration to the configuration storage and the secret to the
+// configured secret storage.
+func StoreClientConfiguration(configuration *ClientConfiguration) error {
+	return GetConfigStore().StoreClientConfiguration(configuration)
+}
+
+// Saves the given server configuration to the configuration storage and the secrets to the
+// configured secret storage.
+func StoreServerConfiguration(configuration *ServerConfiguration) error {
+	return GetConfigStore().StoreServerConfiguration(configuration)
+}
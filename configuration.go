@@ -1,13 +1,179 @@
 package main
 
+import (
+	"time"
+)
+
+// Network types, which may be used as ServerConfiguration.Network.
+const (
+	NETWORK_DUAL_STACK = "udp"
+	NETWORK_IPV4_ONLY  = "udp4"
+	NETWORK_IPV6_ONLY  = "udp6"
+)
+
 type ClientConfiguration struct {
 	Hostname      string
 	Port          uint64
-	Secret        []byte
-	ForwardedKeys []int
+	Secret        []byte `json:"-"`
+	ForwardedKeys KeyList
+	SourceAddress string
+	DeviceName    string
+	KeyID         string
+	Traffic       TrafficConfiguration
+	Queue         QueueConfiguration
+	// Name of the secret storage (see OpenSecretStore), empty for the platform's default.
+	SecretStore string
+	// Profile, the configuration belongs to. Set by the configuration storage.
+	Profile string `json:"-"`
+}
+
+// Returns a client configuration with default values for all optional settings.
+func NewClientConfiguration() *ClientConfiguration {
+	ret := new(ClientConfiguration)
+	ret.ForwardedKeys, _ = KeyPreset(DEFAULT_KEY_PRESET)
+	ret.Traffic = GetDefaultTrafficConfiguration()
+	ret.Queue = GetDefaultQueueConfiguration()
+	return ret
+}
+
+// Traffic shaping of the packets sent to the target machine.
+// Messages are padded to exactly 'PacketSize' bytes. Heartbeats and cover traffic are sent
+// every 'HeartbeatSeconds' seconds and 'CoverMilliseconds' milliseconds. Using cover
+// traffic, keys are sent in place of the next cover packet.
+// Zero values disable the respective feature.
+type TrafficConfiguration struct {
+	PacketSize        int
+	HeartbeatSeconds  int
+	CoverMilliseconds int
+}
+
+// Returns the default traffic shaping: padding only, no heartbeats and no cover traffic.
+func GetDefaultTrafficConfiguration() TrafficConfiguration {
+	return TrafficConfiguration{PacketSize: 128}
 }
 
 type ServerConfiguration struct {
-	Port   uint64
-	Secret []byte
+	Port            uint64
+	Secret          []byte `json:"-"`
+	SecretNotAfter  time.Time
+	KeyGenerations  []KeyGeneration
+	ListenAddresses []string
+	Network         string
+	AllowedNetworks []string
+	DeniedNetworks  []string
+	AllowedDevices  []string
+	DeniedDevices   []string
+	LocalSubnetOnly bool
+	RateLimits      RateLimitConfiguration
+	AllowedKeys     KeyList
+	SenderKeys      map[string]KeyList
+	Clients         []ClientEntry
+	Queue           QueueConfiguration
+	// Name of the secret storage (see OpenSecretStore), empty for the platform's default.
+	SecretStore string
+	// Profile, the configuration belongs to. Set by the configuration storage.
+	Profile string `json:"-"`
+
+	EmitDeadlineMilliseconds int
+}
+
+// Returns a server configuration with default values for all optional settings.
+func NewServerConfiguration() *ServerConfiguration {
+	ret := new(ServerConfiguration)
+	ret.RateLimits = GetDefaultRateLimits()
+	ret.AllowedKeys, _ = KeyPreset(DEFAULT_KEY_PRESET)
+	ret.Queue = GetDefaultQueueConfiguration()
+	ret.EmitDeadlineMilliseconds = DEFAULT_EMIT_DEADLINE_MILLISECONDS
+	return ret
+}
+
+// A client, known to the server by name.
+// The name is used as key ID inside the packet header. Each client has its own secret and,
+// optionally, its own set of permitted keys, which replaces the server's permitted keys.
+type ClientEntry struct {
+	Name        string
+	Secret      []byte `json:"-"`
+	AllowedKeys KeyList
+	Enabled     bool
+}
+
+// A generation of the server's secret.
+// Clients select the generation using its ID as key ID. The generation is accepted from
+// 'NotBefore' until 'NotAfter'. Zero times mean no restriction.
+type KeyGeneration struct {
+	ID        string
+	Secret    []byte `json:"-"`
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// Returns true, if the given time lies within the validity period of the generation.
+func (t *KeyGeneration) IsValid(now time.Time) bool {
+	return (t.NotBefore.IsZero() || !now.Before(t.NotBefore)) && (t.NotAfter.IsZero() || now.Before(t.NotAfter))
+}
+
+// Returns the client entry with the given name or nil, if there is none.
+func (t *ServerConfiguration) FindClient(name string) *ClientEntry {
+	for i := range t.Clients {
+		if t.Clients[i].Name == name {
+			return &t.Clients[i]
+		}
+	}
+	return nil
+}
+
+// Default time, received keys may wait to be emitted by the server.
+const DEFAULT_EMIT_DEADLINE_MILLISECONDS = 2000
+
+// Size and drop policy of the queue between the stages of the event pipeline.
+// The server keeps one queue of this size per sender.
+// The drop policy is one of DROP_OLDEST, DROP_NEWEST or DROP_BLOCK. Using DROP_BLOCK,
+// pushing an event waits up to 'BlockTimeoutMilliseconds' for a free slot.
+type QueueConfiguration struct {
+	Size                     int
+	DropPolicy               string
+	BlockTimeoutMilliseconds int
+}
+
+// Returns the default queue configuration.
+// Dropping the oldest events keeps the latest key presses, which is what users expect
+// when the target machine lags behind.
+func GetDefaultQueueConfiguration() QueueConfiguration {
+	return QueueConfiguration{
+		Size:                     64,
+		DropPolicy:               DROP_OLDEST,
+		BlockTimeoutMilliseconds: 100,
+	}
+}
+
+// Limits for the number of events, the server accepts.
+// Rates are given in events per second, a rate of zero disables the limit.
+// Senders get banned for 'BanSeconds' seconds after 'BanThreshold' consecutive
+// decryption failures. Bans are disabled by default: decryption failures are not
+// authenticated, so spoofed packets could get the address of a legitimate client banned.
+type RateLimitConfiguration struct {
+	SenderRate   float64
+	SenderBurst  int
+	KeyRate      float64
+	KeyBurst     int
+	GlobalRate   float64
+	GlobalBurst  int
+	BanThreshold int
+	BanSeconds   int
+}
+
+// Returns the default rate limits of the server.
+// The limits are generous enough for key repeat, but stop a sender from flooding the
+// target machine with key presses.
+func GetDefaultRateLimits() RateLimitConfiguration {
+	return RateLimitConfiguration{
+		SenderRate:   20,
+		SenderBurst:  40,
+		KeyRate:      10,
+		KeyBurst:     20,
+		GlobalRate:   50,
+		GlobalBurst:  100,
+		BanThreshold: 0,
+		BanSeconds:   300,
+	}
 }
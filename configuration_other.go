@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Returns the configuration storage used without configuration file: the default
+// configuration file, which gets created when storing a configuration.
+func defaultConfigStore(path string, profile string) ConfigStore {
+	return NewFileStore(path, profile)
+}
+
+// Returns false, as there is no registry to migrate from.
+func registryConfigStore(profile string) (ConfigStore, bool) {
+	return nil, false
+}
@@ -1,29 +1,58 @@
+//go:build windows
 // +build windows
+
 package main
 
 import (
 	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"github.com/AllenDang/w32"
-	"github.com/danieljoos/wincred"
+	"os"
+	"sort"
 	"syscall"
 	"unsafe"
 )
 
 const (
+	CONFIGURATION_KEY                   = "Software\\danieljoos\\keyfwd"
+	CONFIGURATION_PROFILES_KEY          = "Software\\danieljoos\\keyfwd\\profiles"
+	CONFIGURATION_DEFAULT_PROFILE       = "DefaultProfile"
 	CLIENT_CONFIGURATION_KEY            = "Software\\danieljoos\\keyfwd\\client"
 	CLIENT_CONFIGURATION_HOSTNAME       = "Hostname"
 	CLIENT_CONFIGURATION_PORT           = "Port"
 	CLIENT_CONFIGURATION_FORWARDED_KEYS = "ForwardedKeys"
-	CLIENT_CONFIGURATION_SECRET         = "danieljoos/keyfwd/client"
+	CLIENT_CONFIGURATION_SOURCE_ADDRESS = "SourceAddress"
+	CLIENT_CONFIGURATION_DEVICE_NAME    = "DeviceName"
+	CLIENT_CONFIGURATION_KEY_ID         = "KeyID"
+	CLIENT_CONFIGURATION_TRAFFIC        = "Traffic"
+	CLIENT_CONFIGURATION_QUEUE          = "Queue"
+	CLIENT_CONFIGURATION_SECRET_STORE   = "SecretStore"
 	SERVER_CONFIGURATION_KEY            = "Software\\danieljoos\\keyfwd\\server"
 	SERVER_CONFIGURATION_PORT           = "Port"
-	SERVER_CONFIGURATION_SECRET         = "danieljoos/keyfwd/server"
+	SERVER_CONFIGURATION_LISTEN         = "ListenAddresses"
+	SERVER_CONFIGURATION_NETWORK        = "Network"
+	SERVER_CONFIGURATION_ALLOW_NETWORKS = "AllowedNetworks"
+	SERVER_CONFIGURATION_DENY_NETWORKS  = "DeniedNetworks"
+	SERVER_CONFIGURATION_ALLOW_DEVICES  = "AllowedDevices"
+	SERVER_CONFIGURATION_DENY_DEVICES   = "DeniedDevices"
+	SERVER_CONFIGURATION_LOCAL_SUBNET   = "LocalSubnetOnly"
+	SERVER_CONFIGURATION_RATE_LIMITS    = "RateLimits"
+	SERVER_CONFIGURATION_ALLOWED_KEYS   = "AllowedKeys"
+	SERVER_CONFIGURATION_SENDER_KEYS    = "SenderKeys"
+	SERVER_CONFIGURATION_CLIENTS        = "Clients"
+	SERVER_CONFIGURATION_GENERATIONS    = "KeyGenerations"
+	SERVER_CONFIGURATION_SECRET_EXPIRY  = "SecretNotAfter"
+	SERVER_CONFIGURATION_QUEUE          = "Queue"
+	SERVER_CONFIGURATION_EMIT_DEADLINE  = "EmitDeadlineMilliseconds"
+	SERVER_CONFIGURATION_SECRET_STORE   = "SecretStore"
 )
 
 var (
-	modadvapi32       = syscall.NewLazyDLL("advapi32.dll")
-	procRegSetValueEx = modadvapi32.NewProc("RegSetValueExW")
+	modadvapi32          = syscall.NewLazyDLL("advapi32.dll")
+	procRegSetValueEx    = modadvapi32.NewProc("RegSetValueExW")
+	procRegDeleteTreeW   = modadvapi32.NewProc("RegDeleteTreeW")
+	procRegQueryInfoKeyW = modadvapi32.NewProc("RegQueryInfoKeyW")
 )
 
 // Store the given string value into the Windows registry.
@@ -69,69 +98,332 @@ func regSetQWORD(hKey w32.HKEY, subKey string, value uint64) (errno int) {
 	return int(ret)
 }
 
-// Returns a new ClientConfiguration object, filled with the configuration data, loaded from the
-// Windows registry (Hostname, Port, ForwardedKeys) and Windows credential store (encryption secret).
-func LoadClientConfiguration() *ClientConfiguration {
-	ret := new(ClientConfiguration)
-	ret.Hostname = w32.RegGetString(w32.HKEY_CURRENT_USER, CLIENT_CONFIGURATION_KEY, CLIENT_CONFIGURATION_HOSTNAME)
-	ret.Port = binary.LittleEndian.Uint64(w32.RegGetRaw(w32.HKEY_CURRENT_USER, CLIENT_CONFIGURATION_KEY, CLIENT_CONFIGURATION_PORT))
-	json.Unmarshal([]byte(w32.RegGetString(w32.HKEY_CURRENT_USER, CLIENT_CONFIGURATION_KEY, CLIENT_CONFIGURATION_FORWARDED_KEYS)), &ret.ForwardedKeys)
-	cred, err := wincred.GetGenericCredential(CLIENT_CONFIGURATION_SECRET)
-	if err == nil {
-		ret.Secret = cred.CredentialBlob
+// Read a QWORD value (64 bit integer) from the Windows registry.
+// Returns the given default value, if the registry value doesn't exist.
+func regGetQWORD(hKey w32.HKEY, subKey string, value string, defaultValue uint64) uint64 {
+	data := w32.RegGetRaw(hKey, subKey, value)
+	if len(data) < 8 {
+		return defaultValue
+	}
+	return binary.LittleEndian.Uint64(data)
+}
+
+// Read a JSON encoded string value from the Windows registry and unmarshal it into
+// the given object. Missing values leave the object untouched.
+// Returns an error wrapping ErrConfigurationUnreadable in case the value can't be decoded.
+func regGetJSON(hKey w32.HKEY, subKey string, value string, v interface{}) error {
+	data := w32.RegGetString(hKey, subKey, value)
+	if len(data) > 0 {
+		if err := json.Unmarshal([]byte(data), v); err != nil {
+			return fmt.Errorf("%w: %s", ErrConfigurationUnreadable, err)
+		}
+	}
+	return nil
+}
+
+// Returns true, if the given registry key exists.
+func regKeyExists(hKey w32.HKEY, subKey string) bool {
+	key := w32.RegOpenKeyEx(hKey, subKey, w32.KEY_READ)
+	if key == 0 {
+		return false
+	}
+	w32.RegCloseKey(key)
+	return true
+}
+
+// Removes the given registry key along with its values and subkeys.
+// Calls the 'RegDeleteTree' function:
+// https://learn.microsoft.com/en-us/windows/win32/api/winreg/nf-winreg-regdeletetreew
+func regDeleteTree(hKey w32.HKEY, subKey string) error {
+	ptr, _ := syscall.UTF16PtrFromString(subKey)
+	ret, _, _ := procRegDeleteTreeW.Call(uintptr(hKey), uintptr(unsafe.Pointer(ptr)))
+	if ret != 0 && ret != uintptr(syscall.ERROR_FILE_NOT_FOUND) {
+		return syscall.Errno(ret)
+	}
+	return nil
+}
+
+// Returns the last write time of the given registry key, or zero if the key doesn't exist.
+// Calls the 'RegQueryInfoKey' function:
+// https://learn.microsoft.com/en-us/windows/win32/api/winreg/nf-winreg-regqueryinfokeyw
+func regLastWriteTime(hKey w32.HKEY, subKey string) int64 {
+	key := w32.RegOpenKeyEx(hKey, subKey, w32.KEY_READ)
+	if key == 0 {
+		return 0
+	}
+	defer w32.RegCloseKey(key)
+	var lastWriteTime syscall.Filetime
+	ret, _, _ := procRegQueryInfoKeyW.Call(uintptr(key), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		uintptr(unsafe.Pointer(&lastWriteTime)))
+	if ret != 0 {
+		return 0
+	}
+	return lastWriteTime.Nanoseconds()
+}
+
+// Returns the names of the subkeys of the given registry key.
+func regSubKeys(hKey w32.HKEY, subKey string) []string {
+	key := w32.RegOpenKeyEx(hKey, subKey, w32.KEY_READ)
+	if key == 0 {
+		return nil
+	}
+	defer w32.RegCloseKey(key)
+	ret := make([]string, 0)
+	for i := uint32(0); ; i++ {
+		name := w32.RegEnumKeyEx(key, i)
+		if len(name) == 0 {
+			return ret
+		}
+		ret = append(ret, name)
+	}
+}
+
+// Store the given object as JSON encoded string value into the Windows registry.
+func regSetJSON(hKey w32.HKEY, subKey string, v interface{}) (errno int) {
+	data, _ := json.Marshal(v)
+	return regSetString(hKey, subKey, string(data))
+}
+
+func boolToQWORD(value bool) uint64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// Configuration storage of a profile in the Windows registry.
+// The default profile uses the keys CLIENT_CONFIGURATION_KEY and SERVER_CONFIGURATION_KEY,
+// other profiles use the subkeys "client" and "server" of their key below
+// CONFIGURATION_PROFILES_KEY.
+type _RegistryStore struct {
+	profile string
+}
+
+func NewRegistryStore(profile string) *_RegistryStore {
+	ret := new(_RegistryStore)
+	ret.profile = profile
+	return ret
+}
+
+// Returns the registry store, which is the default on Windows.
+func defaultConfigStore(path string, profile string) ConfigStore {
+	return NewRegistryStore(profile)
+}
+
+// Returns the registry store as the source of "config migrate".
+func registryConfigStore(profile string) (ConfigStore, bool) {
+	return NewRegistryStore(profile), true
+}
+
+func (t *_RegistryStore) Location() string {
+	return "registry (HKEY_CURRENT_USER\\Software\\danieljoos\\keyfwd)"
+}
+
+func (t *_RegistryStore) Kind() string {
+	return "registry"
+}
+
+func (t *_RegistryStore) Profile() string {
+	return t.profile
+}
+
+// Returns the registry key of the client configuration of the profile.
+func (t *_RegistryStore) clientKey() string {
+	if t.profile == DEFAULT_PROFILE {
+		return CLIENT_CONFIGURATION_KEY
+	}
+	return CONFIGURATION_PROFILES_KEY + "\\" + t.profile + "\\client"
+}
+
+// Returns the registry key of the server configuration of the profile.
+func (t *_RegistryStore) serverKey() string {
+	if t.profile == DEFAULT_PROFILE {
+		return SERVER_CONFIGURATION_KEY
+	}
+	return CONFIGURATION_PROFILES_KEY + "\\" + t.profile + "\\server"
+}
+
+// Changes with the last write time of the profile's client and server keys.
+// Changes of the secrets alone are not detected.
+func (t *_RegistryStore) Revision() string {
+	return fmt.Sprintf("%d,%d", regLastWriteTime(w32.HKEY_CURRENT_USER, t.clientKey()),
+		regLastWriteTime(w32.HKEY_CURRENT_USER, t.serverKey()))
+}
+
+func (t *_RegistryStore) Profiles() []string {
+	ret := make([]string, 0)
+	if regKeyExists(w32.HKEY_CURRENT_USER, CLIENT_CONFIGURATION_KEY) || regKeyExists(w32.HKEY_CURRENT_USER, SERVER_CONFIGURATION_KEY) {
+		ret = append(ret, DEFAULT_PROFILE)
+	}
+	for _, name := range regSubKeys(w32.HKEY_CURRENT_USER, CONFIGURATION_PROFILES_KEY) {
+		if name != DEFAULT_PROFILE {
+			ret = append(ret, name)
+		}
 	}
+	sort.Strings(ret)
 	return ret
 }
 
-// Saves the given ClientConfiguration object to the Windows registry and Windows credential store.
-func StoreClientConfiguration(configuration *ClientConfiguration) {
+func (t *_RegistryStore) DefaultProfile() string {
+	return w32.RegGetString(w32.HKEY_CURRENT_USER, CONFIGURATION_KEY, CONFIGURATION_DEFAULT_PROFILE)
+}
+
+func (t *_RegistryStore) SetDefaultProfile(name string) error {
+	regKey := w32.RegCreateKey(w32.HKEY_CURRENT_USER, CONFIGURATION_KEY)
+	if errno := regSetString(regKey, CONFIGURATION_DEFAULT_PROFILE, name); errno != 0 {
+		return syscall.Errno(errno)
+	}
+	return nil
+}
+
+func (t *_RegistryStore) DeleteProfile() error {
+	if t.DefaultProfile() == t.profile {
+		if err := t.SetDefaultProfile(""); err != nil {
+			return err
+		}
+	}
+	if t.profile != DEFAULT_PROFILE {
+		return regDeleteTree(w32.HKEY_CURRENT_USER, CONFIGURATION_PROFILES_KEY+"\\"+t.profile)
+	}
+	if err := regDeleteTree(w32.HKEY_CURRENT_USER, CLIENT_CONFIGURATION_KEY); err != nil {
+		return err
+	}
+	return regDeleteTree(w32.HKEY_CURRENT_USER, SERVER_CONFIGURATION_KEY)
+}
+
+// Returns true, if a client configuration was stored before.
+func (t *_RegistryStore) ClientConfigurationExists() bool {
+	return regKeyExists(w32.HKEY_CURRENT_USER, t.clientKey())
+}
+
+// Returns a new ClientConfiguration object, filled with the configuration data, loaded from the
+// Windows registry (Hostname, Port, ForwardedKeys) and the configured secret storage (encryption secret).
+// Returns a ConfigurationError listing every invalid field along with the configuration.
+func (t *_RegistryStore) LoadClientConfiguration() (*ClientConfiguration, error) {
+	problems := &ConfigurationError{Role: "client"}
+	ret := NewClientConfiguration()
+	ret.Profile = t.profile
+	ret.Hostname = w32.RegGetString(w32.HKEY_CURRENT_USER, t.clientKey(), CLIENT_CONFIGURATION_HOSTNAME)
+	ret.Port = regGetQWORD(w32.HKEY_CURRENT_USER, t.clientKey(), CLIENT_CONFIGURATION_PORT, 0)
+	problems.Add(CLIENT_CONFIGURATION_FORWARDED_KEYS, regGetJSON(w32.HKEY_CURRENT_USER, t.clientKey(), CLIENT_CONFIGURATION_FORWARDED_KEYS, &ret.ForwardedKeys))
+	if len(ret.ForwardedKeys) == 0 {
+		ret.ForwardedKeys, _ = KeyPreset(DEFAULT_KEY_PRESET)
+	}
+	ret.SourceAddress = w32.RegGetString(w32.HKEY_CURRENT_USER, t.clientKey(), CLIENT_CONFIGURATION_SOURCE_ADDRESS)
+	ret.DeviceName = w32.RegGetString(w32.HKEY_CURRENT_USER, t.clientKey(), CLIENT_CONFIGURATION_DEVICE_NAME)
+	ret.KeyID = w32.RegGetString(w32.HKEY_CURRENT_USER, t.clientKey(), CLIENT_CONFIGURATION_KEY_ID)
+	problems.Add(CLIENT_CONFIGURATION_TRAFFIC, regGetJSON(w32.HKEY_CURRENT_USER, t.clientKey(), CLIENT_CONFIGURATION_TRAFFIC, &ret.Traffic))
+	problems.Add(CLIENT_CONFIGURATION_QUEUE, regGetJSON(w32.HKEY_CURRENT_USER, t.clientKey(), CLIENT_CONFIGURATION_QUEUE, &ret.Queue))
+	ret.SecretStore = w32.RegGetString(w32.HKEY_CURRENT_USER, t.clientKey(), CLIENT_CONFIGURATION_SECRET_STORE)
+	if len(ret.DeviceName) == 0 {
+		ret.DeviceName, _ = os.Hostname()
+	}
+	problems.Add(CLIENT_CONFIGURATION_SECRET_STORE, loadClientSecret(ret))
+	ret.validate(problems)
+	return ret, problems.Err()
+}
+
+// Saves the given ClientConfiguration object to the Windows registry and the configured secret storage.
+// The secret is stored first, so that a running client reloads the new configuration along with it.
+func (t *_RegistryStore) StoreClientConfiguration(configuration *ClientConfiguration) error {
+	configuration.Profile = t.profile
+	if err := storeClientSecret(configuration); err != nil {
+		return err
+	}
 	jsonForwardedKeys, _ := json.Marshal(configuration.ForwardedKeys)
 
-	regKey := w32.RegCreateKey(w32.HKEY_CURRENT_USER, CLIENT_CONFIGURATION_KEY)
+	regKey := w32.RegCreateKey(w32.HKEY_CURRENT_USER, t.clientKey())
 	regSetString(regKey, CLIENT_CONFIGURATION_HOSTNAME, configuration.Hostname)
 	regSetQWORD(regKey, CLIENT_CONFIGURATION_PORT, configuration.Port)
 	regSetString(regKey, CLIENT_CONFIGURATION_FORWARDED_KEYS, string(jsonForwardedKeys))
+	regSetString(regKey, CLIENT_CONFIGURATION_SOURCE_ADDRESS, configuration.SourceAddress)
+	regSetString(regKey, CLIENT_CONFIGURATION_DEVICE_NAME, configuration.DeviceName)
+	regSetString(regKey, CLIENT_CONFIGURATION_KEY_ID, configuration.KeyID)
+	regSetJSON(regKey, CLIENT_CONFIGURATION_TRAFFIC, configuration.Traffic)
+	regSetJSON(regKey, CLIENT_CONFIGURATION_QUEUE, configuration.Queue)
+	regSetString(regKey, CLIENT_CONFIGURATION_SECRET_STORE, configuration.SecretStore)
+	return nil
+}
+
+func (t *_RegistryStore) StoredClientFields() []string {
+	return regStoredFields(t.clientKey(), NewClientConfiguration())
+}
 
-	cred := wincred.NewGenericCredential(CLIENT_CONFIGURATION_SECRET)
-	cred.CredentialBlob = configuration.Secret
-	cred.Write()
+func (t *_RegistryStore) StoredServerFields() []string {
+	return regStoredFields(t.serverKey(), NewServerConfiguration())
 }
 
-// Load the server related configuration data from the Windows registry and Windows credential store.
-// Returns a ServerConfiguration object.
-func LoadServerConfiguration() *ServerConfiguration {
-	ret := new(ServerConfiguration)
-	ret.Port = binary.LittleEndian.Uint64(w32.RegGetRaw(w32.HKEY_CURRENT_USER, SERVER_CONFIGURATION_KEY, SERVER_CONFIGURATION_PORT))
-	cred, err := wincred.GetGenericCredential(SERVER_CONFIGURATION_SECRET)
-	if err == nil {
-		ret.Secret = cred.CredentialBlob
+// Returns the fields of the given configuration, which have a value below the given key.
+// The registry values are named after the fields.
+func regStoredFields(subKey string, configuration interface{}) []string {
+	ret := make([]string, 0)
+	for _, name := range configurationFields(configuration) {
+		if w32.RegGetRaw(w32.HKEY_CURRENT_USER, subKey, name) != nil {
+			ret = append(ret, name)
+		}
 	}
 	return ret
 }
 
-// Saves the given ServerConfiguration object to the Windows registry and Windows credential store.
-func StoreServerConfiguration(configuration *ServerConfiguration) {
-	regKey := w32.RegCreateKey(w32.HKEY_CURRENT_USER, SERVER_CONFIGURATION_KEY)
-	regSetQWORD(regKey, SERVER_CONFIGURATION_PORT, configuration.Port)
+// Returns true, if a server configuration was stored before.
+func (t *_RegistryStore) ServerConfigurationExists() bool {
+	return regKeyExists(w32.HKEY_CURRENT_USER, t.serverKey())
+}
 
-	cred := wincred.NewGenericCredential(SERVER_CONFIGURATION_SECRET)
-	cred.CredentialBlob = configuration.Secret
-	cred.Write()
-}
-
-// Returns an array of keys to forward to the remote host.
-// As this little tool was intended to forward media keys, the default
-// set consists of those keys.
-func GetDefaultForwardedKeys() []int {
-	return []int{
-		w32.VK_VOLUME_MUTE,
-		w32.VK_VOLUME_DOWN,
-		w32.VK_VOLUME_UP,
-		w32.VK_MEDIA_NEXT_TRACK,
-		w32.VK_MEDIA_PREV_TRACK,
-		w32.VK_MEDIA_STOP,
-		w32.VK_MEDIA_PLAY_PAUSE,
-		w32.VK_PLAY,
-		w32.VK_PAUSE,
+// Load the server related configuration data from the Windows registry and the configured secret storage.
+// Returns a ServerConfiguration object and a ConfigurationError listing every invalid field.
+func (t *_RegistryStore) LoadServerConfiguration() (*ServerConfiguration, error) {
+	problems := &ConfigurationError{Role: "server"}
+	ret := NewServerConfiguration()
+	ret.Profile = t.profile
+	ret.Port = regGetQWORD(w32.HKEY_CURRENT_USER, t.serverKey(), SERVER_CONFIGURATION_PORT, 0)
+	problems.Add(SERVER_CONFIGURATION_LISTEN, regGetJSON(w32.HKEY_CURRENT_USER, t.serverKey(), SERVER_CONFIGURATION_LISTEN, &ret.ListenAddresses))
+	ret.Network = w32.RegGetString(w32.HKEY_CURRENT_USER, t.serverKey(), SERVER_CONFIGURATION_NETWORK)
+	problems.Add(SERVER_CONFIGURATION_ALLOW_NETWORKS, regGetJSON(w32.HKEY_CURRENT_USER, t.serverKey(), SERVER_CONFIGURATION_ALLOW_NETWORKS, &ret.AllowedNetworks))
+	problems.Add(SERVER_CONFIGURATION_DENY_NETWORKS, regGetJSON(w32.HKEY_CURRENT_USER, t.serverKey(), SERVER_CONFIGURATION_DENY_NETWORKS, &ret.DeniedNetworks))
+	problems.Add(SERVER_CONFIGURATION_ALLOW_DEVICES, regGetJSON(w32.HKEY_CURRENT_USER, t.serverKey(), SERVER_CONFIGURATION_ALLOW_DEVICES, &ret.AllowedDevices))
+	problems.Add(SERVER_CONFIGURATION_DENY_DEVICES, regGetJSON(w32.HKEY_CURRENT_USER, t.serverKey(), SERVER_CONFIGURATION_DENY_DEVICES, &ret.DeniedDevices))
+	ret.LocalSubnetOnly = regGetQWORD(w32.HKEY_CURRENT_USER, t.serverKey(), SERVER_CONFIGURATION_LOCAL_SUBNET, 0) != 0
+	problems.Add(SERVER_CONFIGURATION_RATE_LIMITS, regGetJSON(w32.HKEY_CURRENT_USER, t.serverKey(), SERVER_CONFIGURATION_RATE_LIMITS, &ret.RateLimits))
+	problems.Add(SERVER_CONFIGURATION_ALLOWED_KEYS, regGetJSON(w32.HKEY_CURRENT_USER, t.serverKey(), SERVER_CONFIGURATION_ALLOWED_KEYS, &ret.AllowedKeys))
+	problems.Add(SERVER_CONFIGURATION_SENDER_KEYS, regGetJSON(w32.HKEY_CURRENT_USER, t.serverKey(), SERVER_CONFIGURATION_SENDER_KEYS, &ret.SenderKeys))
+	problems.Add(SERVER_CONFIGURATION_CLIENTS, regGetJSON(w32.HKEY_CURRENT_USER, t.serverKey(), SERVER_CONFIGURATION_CLIENTS, &ret.Clients))
+	problems.Add(SERVER_CONFIGURATION_GENERATIONS, regGetJSON(w32.HKEY_CURRENT_USER, t.serverKey(), SERVER_CONFIGURATION_GENERATIONS, &ret.KeyGenerations))
+	problems.Add(SERVER_CONFIGURATION_SECRET_EXPIRY, regGetJSON(w32.HKEY_CURRENT_USER, t.serverKey(), SERVER_CONFIGURATION_SECRET_EXPIRY, &ret.SecretNotAfter))
+	problems.Add(SERVER_CONFIGURATION_QUEUE, regGetJSON(w32.HKEY_CURRENT_USER, t.serverKey(), SERVER_CONFIGURATION_QUEUE, &ret.Queue))
+	ret.EmitDeadlineMilliseconds = int(regGetQWORD(w32.HKEY_CURRENT_USER, t.serverKey(), SERVER_CONFIGURATION_EMIT_DEADLINE, uint64(ret.EmitDeadlineMilliseconds)))
+	ret.SecretStore = w32.RegGetString(w32.HKEY_CURRENT_USER, t.serverKey(), SERVER_CONFIGURATION_SECRET_STORE)
+	problems.Add(SERVER_CONFIGURATION_SECRET_STORE, loadServerSecrets(ret))
+	ret.validate(problems)
+	return ret, problems.Err()
+}
+
+// Saves the given ServerConfiguration object to the Windows registry and the configured secret storage.
+// The secrets are stored first, so that a running server reloads the new configuration along with them.
+func (t *_RegistryStore) StoreServerConfiguration(configuration *ServerConfiguration) error {
+	configuration.Profile = t.profile
+	if err := storeServerSecrets(configuration); err != nil {
+		return err
 	}
+	jsonListenAddresses, _ := json.Marshal(configuration.ListenAddresses)
+
+	regKey := w32.RegCreateKey(w32.HKEY_CURRENT_USER, t.serverKey())
+	regSetQWORD(regKey, SERVER_CONFIGURATION_PORT, configuration.Port)
+	regSetString(regKey, SERVER_CONFIGURATION_LISTEN, string(jsonListenAddresses))
+	regSetString(regKey, SERVER_CONFIGURATION_NETWORK, configuration.Network)
+	regSetJSON(regKey, SERVER_CONFIGURATION_ALLOW_NETWORKS, configuration.AllowedNetworks)
+	regSetJSON(regKey, SERVER_CONFIGURATION_DENY_NETWORKS, configuration.DeniedNetworks)
+	regSetJSON(regKey, SERVER_CONFIGURATION_ALLOW_DEVICES, configuration.AllowedDevices)
+	regSetJSON(regKey, SERVER_CONFIGURATION_DENY_DEVICES, configuration.DeniedDevices)
+	regSetQWORD(regKey, SERVER_CONFIGURATION_LOCAL_SUBNET, boolToQWORD(configuration.LocalSubnetOnly))
+	regSetJSON(regKey, SERVER_CONFIGURATION_RATE_LIMITS, configuration.RateLimits)
+	regSetJSON(regKey, SERVER_CONFIGURATION_ALLOWED_KEYS, configuration.AllowedKeys)
+	regSetJSON(regKey, SERVER_CONFIGURATION_SENDER_KEYS, configuration.SenderKeys)
+	regSetJSON(regKey, SERVER_CONFIGURATION_CLIENTS, configuration.Clients)
+	regSetJSON(regKey, SERVER_CONFIGURATION_GENERATIONS, configuration.KeyGenerations)
+	regSetJSON(regKey, SERVER_CONFIGURATION_SECRET_EXPIRY, configuration.SecretNotAfter)
+	regSetJSON(regKey, SERVER_CONFIGURATION_QUEUE, configuration.Queue)
+	regSetQWORD(regKey, SERVER_CONFIGURATION_EMIT_DEADLINE, uint64(configuration.EmitDeadlineMilliseconds))
+	regSetString(regKey, SERVER_CONFIGURATION_SECRET_STORE, configuration.SecretStore)
+	return nil
 }
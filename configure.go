@@ -2,17 +2,278 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/howeyc/gopass"
+	"io"
+	"log"
 	"os"
 	"strconv"
 	"strings"
 )
 
-// Interactive client configuration.
-func ConfigureClient() {
-	var configuration ClientConfiguration
+// Key finishing the key-learning session.
+const LEARN_FINISH_KEY = Key("Escape")
+
+// Environment variables overriding the stored configuration.
+const (
+	ENV_HOST         = "KEYFWD_HOST"
+	ENV_PORT         = "KEYFWD_PORT"
+	ENV_SECRET_FILE  = "KEYFWD_SECRET_FILE"
+	ENV_KEY_ID       = "KEYFWD_KEY_ID"
+	ENV_SOURCE       = "KEYFWD_SOURCE"
+	ENV_DEVICE       = "KEYFWD_DEVICE"
+	ENV_KEYS         = "KEYFWD_KEYS"
+	ENV_LISTEN       = "KEYFWD_LISTEN"
+	ENV_SECRET_STORE = "KEYFWD_SECRET_STORE"
+	ENV_PAIRING      = "KEYFWD_PAIRING"
+)
+
+// Settings given on the command line or by environment variables, mapping flag names to values.
+type _Settings map[string]string
+
+// Collects the settings of the given flags and environment variables.
+// The given map assigns an environment variable to each flag name. Flags take precedence
+// over environment variables.
+func loadSettings(flags *flag.FlagSet, env map[string]string) _Settings {
+	ret := make(_Settings)
+	for name, variable := range env {
+		if value, ok := os.LookupEnv(variable); ok {
+			ret[name] = value
+		}
+	}
+	flags.Visit(func(f *flag.Flag) {
+		if _, ok := env[f.Name]; ok {
+			ret[f.Name] = f.Value.String()
+		}
+	})
+	return ret
+}
+
+// Setting, which is taken from an environment variable whenever the configuration is
+// loaded to be used.
+type _Override struct {
+	Setting  string
+	Variable string
+	Field    string
+}
+
+// Environment variables overriding the stored client settings at load time. Pairing codes,
+// secret files and the secret storage only take effect when stored by 'configure client'.
+var clientOverrides = []_Override{
+	{"host", ENV_HOST, "Hostname"},
+	{"port", ENV_PORT, "Port"},
+	{"key-id", ENV_KEY_ID, "KeyID"},
+	{"source", ENV_SOURCE, "SourceAddress"},
+	{"device", ENV_DEVICE, "DeviceName"},
+	{"keys", ENV_KEYS, "ForwardedKeys"},
+}
+
+// Environment variables overriding the stored server settings at load time.
+var serverOverrides = []_Override{
+	{"port", ENV_PORT, "Port"},
+	{"listen", ENV_LISTEN, "ListenAddresses"},
+	{"keys", ENV_KEYS, "AllowedKeys"},
+}
+
+// Loads the client configuration in effect: the stored configuration with the settings of
+// the KEYFWD_* environment variables applied (see clientOverrides).
+// Returns the overridden fields, mapped to their environment variables, along with the
+// configuration and a ConfigurationError, just like ConfigStore.LoadClientConfiguration.
+func LoadEffectiveClientConfiguration(store ConfigStore) (*ClientConfiguration, map[string]string, error) {
+	configuration, err := store.LoadClientConfiguration()
+	if errors.Is(err, ErrConfigurationUnreadable) {
+		return configuration, nil, err
+	}
+	problems := &ConfigurationError{Role: "client", Problems: loadProblems(err, configuration.Validate())}
+	overridden := applyOverrides(clientOverrides, problems, func(settings _Settings) error {
+		return applyClientSettings(configuration, settings)
+	})
+	configuration.validate(problems)
+	return configuration, overridden, problems.Err()
+}
+
+// Loads the server configuration in effect: the stored configuration with the settings of
+// the KEYFWD_* environment variables applied (see serverOverrides).
+// Returns the overridden fields, mapped to their environment variables, along with the
+// configuration and a ConfigurationError, just like ConfigStore.LoadServerConfiguration.
+func LoadEffectiveServerConfiguration(store ConfigStore) (*ServerConfiguration, map[string]string, error) {
+	configuration, err := store.LoadServerConfiguration()
+	if errors.Is(err, ErrConfigurationUnreadable) {
+		return configuration, nil, err
+	}
+	problems := &ConfigurationError{Role: "server", Problems: loadProblems(err, configuration.Validate())}
+	overridden := applyOverrides(serverOverrides, problems, func(settings _Settings) error {
+		return applyServerSettings(configuration, settings)
+	})
+	configuration.validate(problems)
+	return configuration, overridden, problems.Err()
+}
+
+// Returns the problems found while loading a configuration, which were not found by
+// validating it: these are still present after the settings were overridden.
+func loadProblems(err error, validation error) []*FieldError {
+	var loaded, validated *ConfigurationError
+	if !errors.As(err, &loaded) {
+		if err != nil {
+			return []*FieldError{{"Configuration", err}}
+		}
+		return nil
+	}
+	found := make(map[string]int)
+	if errors.As(validation, &validated) {
+		for _, problem := range validated.Problems {
+			found[problem.Error()]++
+		}
+	}
+	ret := make([]*FieldError, 0, len(loaded.Problems))
+	for _, problem := range loaded.Problems {
+		if found[problem.Error()] > 0 {
+			found[problem.Error()]--
+			continue
+		}
+		ret = append(ret, problem)
+	}
+	return ret
+}
+
+// Applies the given overrides, whose environment variables are set and not empty, one by
+// one. Invalid values are added to the given problems.
+// Returns the overridden fields, mapped to their environment variables.
+func applyOverrides(overrides []_Override, problems *ConfigurationError, apply func(settings _Settings) error) map[string]string {
+	ret := make(map[string]string)
+	for _, override := range overrides {
+		value := os.Getenv(override.Variable)
+		if len(strings.TrimSpace(value)) == 0 {
+			continue
+		}
+		if err := apply(_Settings{override.Setting: value}); err != nil {
+			problems.Add(override.Variable, err)
+			continue
+		}
+		ret[override.Field] = override.Variable
+	}
+	return ret
+}
+
+// Loads the client configuration in order to change it.
+// Invalid settings are accepted, as the change may fix them. Values, which can't be read,
+// stop the program, as storing the configuration would lose them.
+func loadClientConfigurationForUpdate() *ClientConfiguration {
+	configuration, err := LoadClientConfiguration()
+	if errors.Is(err, ErrConfigurationUnreadable) {
+		log.Fatal(err)
+	}
+	return configuration
+}
+
+// Loads the server configuration in order to change it.
+// Invalid settings are accepted, as the change may fix them. Values, which can't be read,
+// stop the program, as storing the configuration would lose them.
+func loadServerConfigurationForUpdate() *ServerConfiguration {
+	configuration, err := LoadServerConfiguration()
+	if errors.Is(err, ErrConfigurationUnreadable) {
+		log.Fatal(err)
+	}
+	return configuration
+}
+
+// Parses the given UDP port number.
+func parsePort(value string) (uint64, error) {
+	ret, err := strconv.ParseUint(strings.TrimSpace(value), 10, 16)
+	if err != nil || ret == 0 {
+		return 0, fmt.Errorf("invalid port '%s'", value)
+	}
+	return ret, nil
+}
+
+// Reads the secret from the file with the given name, "-" reads from stdin.
+// A trailing line break is removed.
+func readSecretFile(name string) ([]byte, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data = []byte(strings.TrimRight(string(data), "\r\n"))
+	if len(data) == 0 {
+		return nil, fmt.Errorf("secret file '%s' is empty", name)
+	}
+	return data, nil
+}
+
+// Client configuration.
+// Without any settings given by flags or environment variables, the settings are asked for
+// interactively. Otherwise, the given settings replace the stored ones. Given -learn only,
+// an existing configuration is kept, apart from the forwarded keys.
+// Supported flags: -pairing, -host, -port, -secret-file, -secret-store, -key-id, -source,
+// -device, -keys and -learn (choose the forwarded keys by pressing them). The settings of a
+// pairing code are replaced by the other flags.
+func ConfigureClient(args []string) {
+	flags := flag.NewFlagSet("configure client", flag.ExitOnError)
+	learn := flags.Bool("learn", false, "choose the forwarded keys by pressing them")
+	flags.String("pairing", "", "pairing code of the server (see 'configure server -export-pairing')")
+	flags.String("host", "", "hostname of the target machine")
+	flags.String("port", "", "UDP port of the target machine")
+	flags.String("secret-file", "", "file to read the encryption secret from, '-' for stdin")
+	flags.String("secret-store", "", "secret storage to use: wincred, secret-service or file")
+	flags.String("key-id", "", "key ID of the client entry on the server")
+	flags.String("source", "", "local address or network interface to send from")
+	flags.String("device", "", "device name identifying the client")
+	flags.String("keys", "", "comma-separated list of keys and presets to forward")
+	flags.Parse(args)
+	settings := loadSettings(flags, map[string]string{
+		"pairing":      ENV_PAIRING,
+		"host":         ENV_HOST,
+		"port":         ENV_PORT,
+		"secret-file":  ENV_SECRET_FILE,
+		"secret-store": ENV_SECRET_STORE,
+		"key-id":       ENV_KEY_ID,
+		"source":       ENV_SOURCE,
+		"device":       ENV_DEVICE,
+		"keys":         ENV_KEYS,
+	})
+
 	reader := bufio.NewReader(os.Stdin)
+	var configuration *ClientConfiguration
+	var err error
+	if len(settings) == 0 && *learn && ClientConfigurationExists() {
+		configuration = loadClientConfigurationForUpdate()
+	} else if len(settings) == 0 {
+		configuration, err = promptClientConfiguration(reader)
+	} else {
+		configuration = loadClientConfigurationForUpdate()
+		err = applyClientSettings(configuration, settings)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *learn {
+		keys, err := LearnKeys(reader)
+		if err != nil {
+			log.Fatal(err)
+		}
+		configuration.ForwardedKeys = keys
+	}
+	if err := configuration.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := StoreClientConfiguration(configuration); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Asks for the client settings.
+func promptClientConfiguration(reader *bufio.Reader) (*ClientConfiguration, error) {
+	var err error
+	configuration := NewClientConfiguration()
 
 	fmt.Printf("%-10s: ", "Hostname")
 	configuration.Hostname, _ = reader.ReadString(byte('\n'))
@@ -20,30 +281,249 @@ func ConfigureClient() {
 
 	fmt.Printf("%-10s: ", "Port")
 	port, _ := reader.ReadString(byte('\n'))
-	port = strings.Trim(port, "\n\r\t ")
-	configuration.Port, _ = strconv.ParseUint(port, 10, 0)
+	if configuration.Port, err = parsePort(strings.Trim(port, "\n\r\t ")); err != nil {
+		return nil, err
+	}
 
 	fmt.Printf("%-10s: ", "Password")
 	configuration.Secret = gopass.GetPasswdMasked()
 
-	configuration.ForwardedKeys = GetDefaultForwardedKeys()
+	fmt.Printf("%-10s: ", "Key ID")
+	configuration.KeyID, _ = reader.ReadString(byte('\n'))
+	configuration.KeyID = strings.Trim(configuration.KeyID, "\n\r\t ")
 
-	StoreClientConfiguration(&configuration)
+	fmt.Printf("%-10s: ", "Source")
+	configuration.SourceAddress, _ = reader.ReadString(byte('\n'))
+	configuration.SourceAddress = strings.Trim(configuration.SourceAddress, "\n\r\t ")
+	return configuration, nil
 }
 
-// Interactive server configuration
-func ConfigureServer() {
-	var configuration ServerConfiguration
+// Replaces the client settings with the given ones.
+// Returns an error in case one of the settings is invalid.
+func applyClientSettings(configuration *ClientConfiguration, settings _Settings) error {
+	var err error
+	if value, ok := settings["pairing"]; ok {
+		if err := applyPairing(configuration, value); err != nil {
+			return err
+		}
+	}
+	if value, ok := settings["host"]; ok {
+		configuration.Hostname = strings.TrimSpace(value)
+	}
+	if value, ok := settings["port"]; ok {
+		port, err := parsePort(value)
+		if err != nil {
+			return err
+		}
+		configuration.Port = port
+	}
+	if value, ok := settings["secret-file"]; ok {
+		if configuration.Secret, err = readSecretFile(value); err != nil {
+			return err
+		}
+	}
+	if value, ok := settings["secret-store"]; ok {
+		configuration.SecretStore = strings.TrimSpace(value)
+	}
+	if value, ok := settings["key-id"]; ok {
+		configuration.KeyID = strings.TrimSpace(value)
+	}
+	if value, ok := settings["source"]; ok {
+		configuration.SourceAddress = strings.TrimSpace(value)
+	}
+	if value, ok := settings["device"]; ok {
+		configuration.DeviceName = strings.TrimSpace(value)
+	}
+	if value, ok := settings["keys"]; ok {
+		keys, err := ParseKeys(value)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return fmt.Errorf("no keys given")
+		}
+		configuration.ForwardedKeys = keys
+	}
+	return nil
+}
+
+// Lets the user choose keys by pressing them.
+// The keys are captured exclusively and echoed, until LEARN_FINISH_KEY is pressed.
+// Afterwards, the user confirms the resulting set or starts over.
+func LearnKeys(reader *bufio.Reader) ([]Key, error) {
+	for {
+		keys, err := captureKeys()
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) == 0 {
+			fmt.Println("No keys pressed")
+			continue
+		}
+		fmt.Printf("Forward %s? [y/N]: ", FormatKeys(keys))
+		answer, err := reader.ReadString(byte('\n'))
+		if err != nil {
+			return nil, fmt.Errorf("key selection not confirmed")
+		}
+		if strings.EqualFold(strings.Trim(answer, "\n\r\t "), "y") {
+			return keys, nil
+		}
+	}
+}
 
+// Captures the keys pressed by the user until LEARN_FINISH_KEY is pressed.
+// Returns the distinct keys in the order they were pressed.
+func captureKeys() ([]Key, error) {
+	queue := NewEventQueue("learn", GetDefaultQueueConfiguration(), NewCounters())
+	capture := NewKeyboardCapture(nil, queue)
+	capture.Exclusive = true
+	result := make(chan error, 1)
+	go func() {
+		result <- capture.SyncReceive()
+	}()
+
+	fmt.Printf("Press the keys to forward, press %s when done\n", LEARN_FINISH_KEY)
+	keys := make([]Key, 0)
+	for {
+		select {
+		case event := <-queue.Events():
+			key := event.(Key)
+			if key == LEARN_FINISH_KEY {
+				capture.Stop()
+				return keys, <-result
+			}
+			if keySet(keys)[key] {
+				continue
+			}
+			fmt.Printf("  %s\n", key)
+			keys = append(keys, key)
+		case err := <-result:
+			return nil, err
+		}
+	}
+}
+
+// Server configuration.
+// Without any settings given by flags or environment variables, the settings are asked for
+// interactively. Otherwise, the given settings replace the stored ones. Given -learn only,
+// an existing configuration is kept, apart from the forwarded keys.
+// Settings, which are not asked for (e.g. the client entries), are kept.
+// Supported flags: -port, -listen, -secret-file, -secret-store and -keys. Using
+// -export-pairing, a pairing code for a client is printed; without other settings, the
+// stored configuration is left unchanged then.
+func ConfigureServer(args []string) {
+	flags := flag.NewFlagSet("configure server", flag.ExitOnError)
+	exportPairing := flags.Bool("export-pairing", false, "print a pairing code for a client")
+	pairingHost := flags.String("pairing-host", "", "hostname of this machine for the client (default: host name)")
+	pairingClient := flags.String("pairing-client", "", "client entry to pair (default: the server's secret)")
+	flags.String("port", "", "UDP port to listen on")
+	flags.String("listen", "", "comma-separated list of local addresses to listen on")
+	flags.String("secret-file", "", "file to read the encryption secret from, '-' for stdin")
+	flags.String("secret-store", "", "secret storage to use: wincred, secret-service or file")
+	flags.String("keys", "", "comma-separated list of keys and presets to accept")
+	flags.Parse(args)
+	settings := loadSettings(flags, map[string]string{
+		"port":         ENV_PORT,
+		"listen":       ENV_LISTEN,
+		"secret-file":  ENV_SECRET_FILE,
+		"secret-store": ENV_SECRET_STORE,
+		"keys":         ENV_KEYS,
+	})
+
+	configuration := loadServerConfigurationForUpdate()
+	update := len(settings) > 0 || !*exportPairing
+	var err error
+	if len(settings) > 0 {
+		err = applyServerSettings(configuration, settings)
+	} else if update {
+		err = promptServerConfiguration(configuration)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := configuration.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	if update {
+		if err := StoreServerConfiguration(configuration); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *exportPairing {
+		host := *pairingHost
+		if len(host) == 0 {
+			host, _ = os.Hostname()
+		}
+		if err := ExportPairing(configuration, host, *pairingClient); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// Asks for the server settings.
+func promptServerConfiguration(configuration *ServerConfiguration) error {
+	var err error
 	reader := bufio.NewReader(os.Stdin)
 
 	fmt.Printf("%-10s: ", "Port")
 	port, _ := reader.ReadString(byte('\n'))
-	port = strings.Trim(port, "\n\r\t ")
-	configuration.Port, _ = strconv.ParseUint(port, 10, 0)
+	if configuration.Port, err = parsePort(strings.Trim(port, "\n\r\t ")); err != nil {
+		return err
+	}
+
+	fmt.Printf("%-10s: ", "Listen")
+	listen, _ := reader.ReadString(byte('\n'))
+	configuration.ListenAddresses = splitList(listen)
 
 	fmt.Printf("%-10s: ", "Password")
 	configuration.Secret = gopass.GetPasswdMasked()
+	return nil
+}
+
+// Replaces the server settings with the given ones.
+// Returns an error in case one of the settings is invalid.
+func applyServerSettings(configuration *ServerConfiguration, settings _Settings) error {
+	var err error
+	if value, ok := settings["port"]; ok {
+		port, err := parsePort(value)
+		if err != nil {
+			return err
+		}
+		configuration.Port = port
+	}
+	if value, ok := settings["listen"]; ok {
+		configuration.ListenAddresses = splitList(value)
+	}
+	if value, ok := settings["secret-file"]; ok {
+		if configuration.Secret, err = readSecretFile(value); err != nil {
+			return err
+		}
+	}
+	if value, ok := settings["secret-store"]; ok {
+		configuration.SecretStore = strings.TrimSpace(value)
+	}
+	if value, ok := settings["keys"]; ok {
+		keys, err := ParseKeys(value)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return fmt.Errorf("no keys given")
+		}
+		configuration.AllowedKeys = keys
+	}
+	return nil
+}
 
-	StoreServerConfiguration(&configuration)
+// Splits the given comma-separated list, omitting empty entries.
+func splitList(value string) []string {
+	var ret []string
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.Trim(entry, "\n\r\t ")
+		if len(entry) > 0 {
+			ret = append(ret, entry)
+		}
+	}
+	return ret
 }
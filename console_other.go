@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// There is no console window to hide on other platforms.
+func HideConsoleWindow() {
+}
+
+func ShowConsoleWindow() {
+}
+
+func ToggleShowConsoleWindow() {
+}
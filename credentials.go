@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// Names of the secrets inside the secret storage.
+// The secrets of profiles other than the default profile are prefixed by
+// PROFILE_SECRET_PREFIX and the profile name (see profileSecretName).
+const (
+	CLIENT_CONFIGURATION_SECRET        = "danieljoos/keyfwd/client"
+	SERVER_CONFIGURATION_SECRET        = "danieljoos/keyfwd/server"
+	SERVER_CONFIGURATION_CLIENT_SECRET = "danieljoos/keyfwd/server/client/"
+	SERVER_CONFIGURATION_KEY_SECRET    = "danieljoos/keyfwd/server/key/"
+	PROFILE_SECRET_PREFIX              = "danieljoos/keyfwd/profile/"
+)
+
+// Returns the name of the given secret within the given profile, e.g.
+// "danieljoos/keyfwd/profile/office/client".
+func profileSecretName(profile string, name string) string {
+	if len(profile) == 0 || profile == DEFAULT_PROFILE {
+		return name
+	}
+	return PROFILE_SECRET_PREFIX + profile + "/" + strings.TrimPrefix(name, "danieljoos/keyfwd/")
+}
+
+// Loads the secret of the given client configuration from the configured secret storage.
+// A missing secret is no error; it is reported by the validation instead.
+func loadClientSecret(configuration *ClientConfiguration) error {
+	store, err := OpenSecretStore(configuration.SecretStore)
+	if err != nil {
+		return err
+	}
+	return loadSecret(store, profileSecretName(configuration.Profile, CLIENT_CONFIGURATION_SECRET), &configuration.Secret)
+}
+
+// Saves the secret of the given client configuration to the configured secret storage.
+// Without a configured secret storage, the default one is chosen and set in the
+// configuration, so the secret is found regardless of the session loading it later (see
+// defaultSecretStore). Thus, call this before saving the configuration itself.
+func storeClientSecret(configuration *ClientConfiguration) error {
+	configuration.SecretStore = normalizeSecretStore(configuration.SecretStore)
+	store, err := OpenSecretStore(configuration.SecretStore)
+	if err != nil {
+		return err
+	}
+	return storeSecret(store, profileSecretName(configuration.Profile, CLIENT_CONFIGURATION_SECRET), configuration.Secret)
+}
+
+// Loads the default secret, the secrets of the client entries and the secrets of the key
+// generations of the given server configuration from the configured secret storage.
+// Returns the first error other than a missing secret.
+func loadServerSecrets(configuration *ServerConfiguration) error {
+	store, err := OpenSecretStore(configuration.SecretStore)
+	if err != nil {
+		return err
+	}
+	errs := []error{loadSecret(store, profileSecretName(configuration.Profile, SERVER_CONFIGURATION_SECRET), &configuration.Secret)}
+	for i := range configuration.Clients {
+		errs = append(errs, loadSecret(store, profileSecretName(configuration.Profile, SERVER_CONFIGURATION_CLIENT_SECRET+configuration.Clients[i].Name), &configuration.Clients[i].Secret))
+	}
+	for i := range configuration.KeyGenerations {
+		errs = append(errs, loadSecret(store, profileSecretName(configuration.Profile, SERVER_CONFIGURATION_KEY_SECRET+configuration.KeyGenerations[i].ID), &configuration.KeyGenerations[i].Secret))
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Saves the secrets of the given server configuration to the configured secret storage.
+// Client entries and key generations without secret are skipped. The secret storage is
+// set in the configuration, just like storeClientSecret does.
+func storeServerSecrets(configuration *ServerConfiguration) error {
+	configuration.SecretStore = normalizeSecretStore(configuration.SecretStore)
+	store, err := OpenSecretStore(configuration.SecretStore)
+	if err != nil {
+		return err
+	}
+	if err := storeSecret(store, profileSecretName(configuration.Profile, SERVER_CONFIGURATION_SECRET), configuration.Secret); err != nil {
+		return err
+	}
+	for _, client := range configuration.Clients {
+		if len(client.Secret) > 0 {
+			if err := store.Store(profileSecretName(configuration.Profile, SERVER_CONFIGURATION_CLIENT_SECRET+client.Name), client.Secret); err != nil {
+				return err
+			}
+		}
+	}
+	for _, generation := range configuration.KeyGenerations {
+		if len(generation.Secret) > 0 {
+			if err := store.Store(profileSecretName(configuration.Profile, SERVER_CONFIGURATION_KEY_SECRET+generation.ID), generation.Secret); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Removes the secret of the given client configuration from the configured secret storage.
+func deleteClientSecret(configuration *ClientConfiguration) error {
+	store, err := OpenSecretStore(configuration.SecretStore)
+	if err != nil {
+		return err
+	}
+	return store.Delete(profileSecretName(configuration.Profile, CLIENT_CONFIGURATION_SECRET))
+}
+
+// Removes all secrets of the given server configuration from the configured secret storage.
+func deleteServerSecrets(configuration *ServerConfiguration) error {
+	store, err := OpenSecretStore(configuration.SecretStore)
+	if err != nil {
+		return err
+	}
+	names := []string{SERVER_CONFIGURATION_SECRET}
+	for _, client := range configuration.Clients {
+		names = append(names, SERVER_CONFIGURATION_CLIENT_SECRET+client.Name)
+	}
+	for _, generation := range configuration.KeyGenerations {
+		names = append(names, SERVER_CONFIGURATION_KEY_SECRET+generation.ID)
+	}
+	for _, name := range names {
+		if err := store.Delete(profileSecretName(configuration.Profile, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Removes the secret of the key generation with the given ID.
+func DeleteServerKeySecret(configuration *ServerConfiguration, id string) error {
+	store, err := OpenSecretStore(configuration.SecretStore)
+	if err != nil {
+		return err
+	}
+	return store.Delete(profileSecretName(configuration.Profile, SERVER_CONFIGURATION_KEY_SECRET+id))
+}
+
+// Removes the secret of the client entry with the given name.
+func DeleteServerClientSecret(configuration *ServerConfiguration, name string) error {
+	store, err := OpenSecretStore(configuration.SecretStore)
+	if err != nil {
+		return err
+	}
+	return store.Delete(profileSecretName(configuration.Profile, SERVER_CONFIGURATION_CLIENT_SECRET+name))
+}
+
+// Loads the secret with the given name into 'secret'.
+// A missing secret leaves 'secret' unchanged.
+func loadSecret(store SecretStore, name string, secret *[]byte) error {
+	value, err := store.Load(name)
+	if errors.Is(err, ErrSecretNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	*secret = value
+	return nil
+}
+
+// Saves the secret with the given name. An empty secret removes the stored one.
+func storeSecret(store SecretStore, name string, secret []byte) error {
+	if len(secret) == 0 {
+		return store.Delete(name)
+	}
+	return store.Store(name, secret)
+}
@@ -37,11 +37,15 @@ func (t *Encryption) Encrypt(data []byte) []byte {
 // The function expects the given data to include the initialization vector
 // within the first bytes (0 to BlockSize(16)). The remaining bytes contain the actual
 // data to decrypt.
-// Returns the decrypted data as byte array.
-func (t *Encryption) Decrypt(data []byte) []byte {
+// Returns the decrypted data as byte array or ErrPacketTruncated, if the data is shorter
+// than the initialization vector.
+func (t *Encryption) Decrypt(data []byte) ([]byte, error) {
 	blockSize := t.cipher.BlockSize()
+	if len(data) < blockSize {
+		return nil, ErrPacketTruncated
+	}
 	decrypter := cipher.NewCFBDecrypter(t.cipher, data[0:blockSize])
 	ret := make([]byte, len(data)-blockSize)
-	decrypter.XORKeyStream(ret, data[blockSize:len(data)])
-	return ret
+	decrypter.XORKeyStream(ret, data[blockSize:])
+	return ret, nil
 }
@@ -0,0 +1,36 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+)
+
+// Returned on platforms without key interception.
+var errNoKeyboardCapture = errors.New("capturing keys is not supported on this platform")
+
+type KeyboardCapture struct {
+	KeyPressed *_EventQueue
+	// Captured keys are not passed on to other applications.
+	Exclusive bool
+}
+
+// Create a new KeyboardCapture object.
+// Capturing keys is only supported on Windows, SyncReceive returns an error elsewhere.
+func NewKeyboardCapture(forwardedKeys []Key, queue *_EventQueue) *KeyboardCapture {
+	ret := new(KeyboardCapture)
+	ret.KeyPressed = queue
+	return ret
+}
+
+// Returns an error, as capturing keys is not supported on this platform.
+func (t *KeyboardCapture) SyncReceive() error {
+	return errNoKeyboardCapture
+}
+
+func (t *KeyboardCapture) SetForwardedKeys(forwardedKeys []Key) {
+}
+
+func (t *KeyboardCapture) Stop() {
+}
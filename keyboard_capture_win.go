@@ -1,28 +1,39 @@
+//go:build windows
 // +build windows
+
 package main
 
 import (
 	"github.com/AllenDang/w32"
+	"runtime"
+	"sync"
 	"syscall"
 	"unsafe"
 )
 
 type KeyboardCapture struct {
 	keyboardHook  w32.HHOOK
-	forwardedKeys []int
+	forwardedKeys map[Key]bool
+	threadId      uint32
+	stopped       bool
+	lock          sync.Mutex
 
-	KeyPressed chan int
+	KeyPressed *_EventQueue
+	// Captured keys are not passed on to other applications.
+	Exclusive bool
 }
 
 // Create a new KeyboardCapture object.
 // The object will only 'capture' the keys, specified in the given
-// integer array.
-// Specify keys by using the VK_* constants of Windows:
-// http://msdn.microsoft.com/en-us/library/windows/desktop/dd375731(v=vs.85).aspx
-func NewKeyboardCapture(forwardedKeys []int) *KeyboardCapture {
+// array. Captured keys are pushed to the given queue.
+// Passing nil captures all keys except the modifiers, which are reported as part of
+// key combinations instead.
+func NewKeyboardCapture(forwardedKeys []Key, queue *_EventQueue) *KeyboardCapture {
 	ret := new(KeyboardCapture)
-	ret.forwardedKeys = forwardedKeys
-	ret.KeyPressed = make(chan int)
+	if forwardedKeys != nil {
+		ret.forwardedKeys = keySet(forwardedKeys)
+	}
+	ret.KeyPressed = queue
 	return ret
 }
 
@@ -30,36 +41,61 @@ func NewKeyboardCapture(forwardedKeys []int) *KeyboardCapture {
 // http://msdn.microsoft.com/en-us/library/windows/desktop/ms644990(v=vs.85).aspx
 //
 // Each intercepted key, which was included in the 'forwardedKeys' configuration
-// variable (see NewKeyboardCapture), will be pushed to the 'KeyPressed' channel field.
+// variable (see NewKeyboardCapture), will be pushed to the 'KeyPressed' queue field.
+// Keep the block timeout of the queue short, as Windows skips hooks taking too long.
 // Returns an error in case the initialization of the hook failed.
 // Calls to this function will block until KeyboardCapture.Stop() was called or the
-// WM_QUIT message was sent to the current process.
+// WM_QUIT message was sent to the calling thread.
 func (t *KeyboardCapture) SyncReceive() error {
-	isValidKey := func(key w32.DWORD) bool {
-		for _, e := range t.forwardedKeys {
-			if e == int(key) {
-				return true
-			}
-		}
-		return false
-	}
-	t.KeyPressed = make(chan int)
+	// The hook procedure gets called on the thread, which installed the hook.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
 	t.keyboardHook = w32.SetWindowsHookEx(w32.WH_KEYBOARD_LL,
 		(w32.HOOKPROC)(func(code int, wparam w32.WPARAM, lparam w32.LPARAM) w32.LRESULT {
-			if code >= 0 && wparam == w32.WM_KEYDOWN {
-				kbdstruct := (*w32.KBDLLHOOKSTRUCT)(unsafe.Pointer(lparam))
-				if isValidKey(kbdstruct.VkCode) {
-					select {
-					case t.KeyPressed <- int(kbdstruct.VkCode):
-					default:
-					}
+			if code < 0 {
+				return w32.CallNextHookEx(t.keyboardHook, code, wparam, lparam)
+			}
+			kbdstruct := (*w32.KBDLLHOOKSTRUCT)(unsafe.Pointer(lparam))
+			key := KeyFromWindows(int(kbdstruct.VkCode))
+			// Keys pressed while holding the ALT key are reported as system keys.
+			isKeyDown := wparam == w32.WM_KEYDOWN || wparam == w32.WM_SYSKEYDOWN
+			captured := false
+			t.lock.Lock()
+			forwardedKeys := t.forwardedKeys
+			t.lock.Unlock()
+			if forwardedKeys == nil {
+				captured = !key.IsModifier()
+				if captured && isKeyDown {
+					t.KeyPressed.Push(KeyCombination(pressedModifiers(), key))
 				}
+			} else if isKeyDown {
+				combination := KeyCombination(pressedModifiers(), key)
+				if forwardedKeys[combination] {
+					t.KeyPressed.Push(combination)
+					captured = true
+				} else if forwardedKeys[key] {
+					t.KeyPressed.Push(key)
+					captured = true
+				}
+			}
+			if captured && t.Exclusive {
+				return 1
 			}
 			return w32.CallNextHookEx(t.keyboardHook, code, wparam, lparam)
 		}), 0, 0)
 	if t.keyboardHook == 0 {
 		return syscall.GetLastError()
 	}
+	t.lock.Lock()
+	t.threadId = getCurrentThreadId()
+	stopped := t.stopped
+	t.lock.Unlock()
+	if stopped {
+		w32.UnhookWindowsHookEx(t.keyboardHook)
+		t.keyboardHook = 0
+		return nil
+	}
 	var msg w32.MSG
 	for w32.GetMessage(&msg, 0, 0, 0) != 0 {
 	}
@@ -69,8 +105,46 @@ func (t *KeyboardCapture) SyncReceive() error {
 	return nil
 }
 
-// Stops the key interception by sending the quit message (WM_QUIT) to the current
-// process.
+// Returns the modifiers, which are currently held down:
+// http://msdn.microsoft.com/en-us/library/windows/desktop/ms646293(v=vs.85).aspx
+func pressedModifiers() []Key {
+	isPressed := func(vk int) bool {
+		return w32.GetAsyncKeyState(vk)&0x8000 != 0
+	}
+	ret := make([]Key, 0, len(keyModifiers))
+	if isPressed(w32.VK_CONTROL) {
+		ret = append(ret, "Control")
+	}
+	if isPressed(w32.VK_SHIFT) {
+		ret = append(ret, "Shift")
+	}
+	if isPressed(w32.VK_MENU) {
+		ret = append(ret, "Alt")
+	}
+	if isPressed(w32.VK_LWIN) || isPressed(w32.VK_RWIN) {
+		ret = append(ret, "Meta")
+	}
+	return ret
+}
+
+// Replaces the keys to capture. Takes effect with the next key press.
+func (t *KeyboardCapture) SetForwardedKeys(forwardedKeys []Key) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.forwardedKeys = nil
+	if forwardedKeys != nil {
+		t.forwardedKeys = keySet(forwardedKeys)
+	}
+}
+
+// Stops the key interception by sending the quit message (WM_QUIT) to the thread
+// running KeyboardCapture.SyncReceive().
+// May be called before SyncReceive() started, which then returns immediately.
 func (t *KeyboardCapture) Stop() {
-	w32.PostQuitMessage(0)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.stopped = true
+	if t.threadId != 0 {
+		postThreadQuit(t.threadId)
+	}
 }
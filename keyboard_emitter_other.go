@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+)
+
+type KeyboardEmitter struct {
+}
+
+func NewKeyboardEmitter() *KeyboardEmitter {
+	ret := new(KeyboardEmitter)
+	return ret
+}
+
+// Returns an error, as emitting keys is not supported on this platform.
+func (t *KeyboardEmitter) SendKey(key Key) error {
+	return fmt.Errorf("unable to emit key '%s': not supported on this platform", key)
+}
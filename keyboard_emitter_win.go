@@ -1,29 +1,57 @@
+//go:build windows
 // +build windows
+
 package main
 
 import (
+	"fmt"
 	"github.com/AllenDang/w32"
 )
 
 type KeyboardEmitter struct {
-	input [1]w32.INPUT
 }
 
 func NewKeyboardEmitter() *KeyboardEmitter {
 	ret := new(KeyboardEmitter)
-	ret.input[0].Type = w32.INPUT_KEYBOARD
-	ret.input[0].Ki.WScan = 0
-	ret.input[0].Ki.Time = 0
-	ret.input[0].Ki.DwExtraInfo = 0
-	ret.input[0].Ki.WVk = 0
-	ret.input[0].Ki.DwFlags = 0
 	return ret
 }
 
-func (t *KeyboardEmitter) SendKey(key int) {
-	t.input[0].Ki.WVk = uint16(key)
-	t.input[0].Ki.DwFlags = 0
-	w32.SendInput(t.input[:])
-	t.input[0].Ki.DwFlags = 2
-	w32.SendInput(t.input[:])
+// Emits a key press of the given key using the SendInput function:
+// http://msdn.microsoft.com/en-us/library/windows/desktop/ms646310(v=vs.85).aspx
+// The modifiers of key combinations are pressed before and released after the key.
+// Returns an error in case one of the keys has no Windows virtual-key code.
+func (t *KeyboardEmitter) SendKey(key Key) error {
+	modifiers, base := key.Split()
+	keys := make([]Key, 0, len(modifiers)+1)
+	for _, modifier := range modifiers {
+		keys = append(keys, modifierKey(modifier))
+	}
+	keys = append(keys, base)
+
+	codes := make([]uint16, len(keys))
+	for i, k := range keys {
+		vk, ok := k.Windows()
+		if !ok || vk > 0xFF {
+			return fmt.Errorf("key %s is not supported on Windows", key)
+		}
+		codes[i] = uint16(vk)
+	}
+
+	input := make([]w32.INPUT, 0, 2*len(codes))
+	for _, vk := range codes {
+		input = append(input, keyboardInput(vk, 0))
+	}
+	for i := len(codes) - 1; i >= 0; i-- {
+		input = append(input, keyboardInput(codes[i], 2))
+	}
+	w32.SendInput(input)
+	return nil
+}
+
+func keyboardInput(vk uint16, flags uint32) w32.INPUT {
+	var ret w32.INPUT
+	ret.Type = w32.INPUT_KEYBOARD
+	ret.Ki.WVk = vk
+	ret.Ki.DwFlags = flags
+	return ret
 }
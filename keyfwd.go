@@ -1,17 +1,29 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
+	"reflect"
 	"syscall"
 )
 
+// Client or server.
+// Start blocks until the given context is cancelled or an error occurs. It returns nil
+// on a clean shutdown.
 type Runnable interface {
-	Start() error
-	Stop()
+	Start(ctx context.Context) error
 }
 
+// Type used for tracking interaction with the notify icon
+type NotifyIconButton int
+
+const (
+	LeftMouseButton NotifyIconButton = iota
+	RightMouseButton
+)
+
 type NotifyIcon interface {
 	Start() error
 	Stop()
@@ -19,67 +31,90 @@ type NotifyIcon interface {
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		log.Fatal("Missing argument")
-	}
+	os.Exit(RunCommand(commandTree(), os.Args[1:]))
+}
 
+// Runs the client or server until it is stopped.
+func Run(role string) {
 	var action Runnable
 	var notifyIcon NotifyIcon
+	var store ConfigStore
+	var reload func() error
 	var err error
 
-	switch os.Args[1] {
+	switch role {
 	case "client":
-		action = NewClient(LoadClientConfiguration())
+		store = GetConfigStore()
+		var configuration *ClientConfiguration
+		if configuration, _, err = LoadEffectiveClientConfiguration(store); err != nil {
+			log.Fatal(err)
+		}
+		client := NewClient(configuration)
+		reload = func() error {
+			next, _, err := LoadEffectiveClientConfiguration(store)
+			if err != nil {
+				return err
+			}
+			if reflect.DeepEqual(next, configuration) {
+				return nil
+			}
+			if err := client.Reload(next); err != nil {
+				return err
+			}
+			configuration = next
+			return nil
+		}
+		action = client
 		notifyIcon, err = NewNotifyIcon("Key Forwarding (client)", IconClient)
 	case "server":
-		action = NewServer(LoadServerConfiguration())
-		notifyIcon, err = NewNotifyIcon("Key Forwarding (server)", IconServer)
-	case "configure":
-		if len(os.Args) < 3 {
-			log.Fatal("Missing argument")
+		store = GetConfigStore()
+		var configuration *ServerConfiguration
+		if configuration, _, err = LoadEffectiveServerConfiguration(store); err != nil {
+			log.Fatal(err)
 		}
-		switch os.Args[2] {
-		case "client":
-			ConfigureClient()
-		case "server":
-			ConfigureServer()
-		default:
-			log.Fatal("Unknown configuration target")
+		server := NewServer(configuration)
+		reload = func() error {
+			next, _, err := LoadEffectiveServerConfiguration(store)
+			if err != nil {
+				return err
+			}
+			if reflect.DeepEqual(next, configuration) {
+				return nil
+			}
+			if err := server.Reload(next); err != nil {
+				return err
+			}
+			configuration = next
+			return nil
 		}
-		os.Exit(0)
+		action = server
+		notifyIcon, err = NewNotifyIcon("Key Forwarding (server)", IconServer)
 	default:
-		log.Fatal("Unknown action")
+		log.Fatal("Unknown role")
 	}
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	quit := make(chan bool)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
 
-	// Shutdown signal handler
+	// Keyboard interception
+	result := make(chan error, 1)
 	go func() {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
-		for {
-			select {
-			case <-c:
-				quit <- true
-			case <-quit:
-			}
-			log.Println("Shutdown signal received")
-			action.Stop()
-			notifyIcon.Stop()
-			os.Exit(0)
-		}
+		result <- action.Start(ctx)
+		cancel()
 	}()
 
-	// Keyboard interception
+	// Configuration reload on change or SIGHUP
+	go WatchConfiguration(ctx, store, reload)
+
+	// Shutdown handler
 	go func() {
-		err := action.Start()
-		if err != nil {
-			log.Fatal(err)
-		}
+		<-ctx.Done()
+		logInfo("Shutdown signal received")
+		notifyIcon.Stop()
 	}()
 
 	// Notify icon click handler
@@ -92,10 +127,10 @@ func main() {
 					ToggleShowConsoleWindow()
 				case RightMouseButton:
 					ShowConsoleWindow()
-					quit <- true
+					cancel()
 					return
 				}
-			case <-quit:
+			case <-ctx.Done():
 				return
 			}
 		}
@@ -103,6 +138,10 @@ func main() {
 
 	// Notify icon run
 	err = notifyIcon.Start()
+	cancel()
+	if actionErr := <-result; actionErr != nil {
+		log.Fatal(actionErr)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
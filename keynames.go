@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Name of the key preset used, if no keys are configured.
+// As this little tool was intended to forward media keys, the default
+// set consists of those keys. The server accepts the same set of keys by default.
+const DEFAULT_KEY_PRESET = "media"
+
+// Named sets of keys, which may be used in place of single keys within key lists.
+var keyPresets = map[string][]Key{
+	"media": {
+		"AudioVolumeMute",
+		"AudioVolumeDown",
+		"AudioVolumeUp",
+		"MediaTrackNext",
+		"MediaTrackPrevious",
+		"MediaStop",
+		"MediaPlayPause",
+		"MediaPlay",
+		"Pause",
+	},
+	"function-keys": {
+		"F1", "F2", "F3", "F4", "F5", "F6", "F7", "F8", "F9", "F10", "F11", "F12",
+		"F13", "F14", "F15", "F16", "F17", "F18", "F19", "F20", "F21", "F22", "F23", "F24",
+	},
+	"numpad": {
+		"NumLock", "NumpadDivide", "NumpadMultiply", "NumpadSubtract", "NumpadAdd",
+		"NumpadEnter", "NumpadDecimal", "Numpad0", "Numpad1", "Numpad2", "Numpad3",
+		"Numpad4", "Numpad5", "Numpad6", "Numpad7", "Numpad8", "Numpad9",
+	},
+}
+
+// Alternative names of keys, in addition to the names of the key vocabulary.
+var keyAliases = map[string]Key{
+	"VolumeMute":    "AudioVolumeMute",
+	"Mute":          "AudioVolumeMute",
+	"VolumeDown":    "AudioVolumeDown",
+	"VolumeUp":      "AudioVolumeUp",
+	"MediaNext":     "MediaTrackNext",
+	"NextTrack":     "MediaTrackNext",
+	"MediaPrev":     "MediaTrackPrevious",
+	"MediaPrevious": "MediaTrackPrevious",
+	"PrevTrack":     "MediaTrackPrevious",
+	"PlayPause":     "MediaPlayPause",
+	"Play":          "MediaPlay",
+	"Stop":          "MediaStop",
+	"Mail":          "LaunchMail",
+	"MediaSelect":   "LaunchMediaPlayer",
+	"Calculator":    "LaunchApp2",
+	"Esc":           "Escape",
+	"Return":        "Enter",
+	"Del":           "Delete",
+	"Ins":           "Insert",
+	"PgUp":          "PageUp",
+	"PgDn":          "PageDown",
+	"Up":            "ArrowUp",
+	"Down":          "ArrowDown",
+	"Left":          "ArrowLeft",
+	"Right":         "ArrowRight",
+	"Menu":          "ContextMenu",
+	"Apps":          "ContextMenu",
+	"PrtSc":         "PrintScreen",
+	"Break":         "Pause",
+	"Ctrl":          "ControlLeft",
+	"Win":           "MetaLeft",
+	"Super":         "MetaLeft",
+	"Cmd":           "MetaLeft",
+}
+
+// Alternative names of the modifiers within key combinations.
+var modifierAliases = map[string]Key{
+	"ctrl":         "Control",
+	"control":      "Control",
+	"controlleft":  "Control",
+	"controlright": "Control",
+	"shift":        "Shift",
+	"shiftleft":    "Shift",
+	"shiftright":   "Shift",
+	"alt":          "Alt",
+	"altleft":      "Alt",
+	"altright":     "Alt",
+	"option":       "Alt",
+	"meta":         "Meta",
+	"metaleft":     "Meta",
+	"metaright":    "Meta",
+	"win":          "Meta",
+	"super":        "Meta",
+	"cmd":          "Meta",
+}
+
+// Returns true, if the key is one of the modifier keys (e.g. "ControlLeft", "Shift").
+func (t Key) IsModifier() bool {
+	_, ok := modifierAliases[strings.ToLower(string(t))]
+	return ok
+}
+
+// Lower-case names and aliases of all keys of the vocabulary.
+var keysByLowerName = make(map[string]Key)
+
+func init() {
+	for _, codes := range keyTable {
+		keysByLowerName[strings.ToLower(string(codes.Name))] = codes.Name
+	}
+	for c := 'A'; c <= 'Z'; c++ {
+		keyAliases[string(c)] = Key("Key" + string(c))
+	}
+	for c := '0'; c <= '9'; c++ {
+		keyAliases[string(c)] = Key("Digit" + string(c))
+	}
+	for alias, key := range keyAliases {
+		keysByLowerName[strings.ToLower(alias)] = key
+	}
+}
+
+// Parses the given key name.
+// Accepts the names of the key vocabulary and their aliases (case-insensitive, e.g. "VolumeUp",
+// "MediaNext", "F13"), platform-specific keys ("Windows:255", "Linux:240"), Windows virtual-key
+// codes ("179", "0xB3") and key combinations ("Ctrl+Alt+P").
+// Returns the canonical key or an error in case the name is unknown.
+func ParseKey(name string) (Key, error) {
+	parts := strings.Split(strings.TrimSpace(name), KEY_COMBINATION_SEPARATOR)
+	key, err := parseSingleKey(parts[len(parts)-1])
+	if err != nil {
+		return "", err
+	}
+	modifiers := make([]Key, 0, len(parts)-1)
+	for _, part := range parts[:len(parts)-1] {
+		modifier, ok := modifierAliases[strings.ToLower(strings.TrimSpace(part))]
+		if !ok {
+			return "", fmt.Errorf("unknown modifier '%s' in key '%s'", part, name)
+		}
+		modifiers = append(modifiers, modifier)
+	}
+	return KeyCombination(modifiers, key), nil
+}
+
+func parseSingleKey(name string) (Key, error) {
+	name = strings.TrimSpace(name)
+	if key, ok := keysByLowerName[strings.ToLower(name)]; ok {
+		return key, nil
+	}
+	for _, prefix := range []string{KEY_PREFIX_WINDOWS, KEY_PREFIX_LINUX} {
+		if len(name) > len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+			code, err := strconv.ParseInt(name[len(prefix):], 0, 32)
+			if err != nil || code <= 0 {
+				return "", fmt.Errorf("invalid key code in key '%s'", name)
+			}
+			if prefix == KEY_PREFIX_WINDOWS {
+				return KeyFromWindows(int(code)), nil
+			}
+			return KeyFromLinux(int(code)), nil
+		}
+	}
+	if code, err := strconv.ParseInt(name, 0, 32); err == nil && code > 0 {
+		return KeyFromWindows(int(code)), nil
+	}
+	return "", fmt.Errorf("unknown key '%s'", name)
+}
+
+// Parses a comma-separated list of key names and preset names.
+// Presets are replaced by their keys.
+func ParseKeys(names string) ([]Key, error) {
+	ret := make([]Key, 0)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		keys, err := parseKeyOrPreset(name)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, keys...)
+	}
+	return ret, nil
+}
+
+func parseKeyOrPreset(name string) ([]Key, error) {
+	if keys, ok := KeyPreset(name); ok {
+		return keys, nil
+	}
+	key, err := ParseKey(name)
+	if err != nil {
+		return nil, err
+	}
+	return []Key{key}, nil
+}
+
+// Returns the keys of the preset with the given name.
+// Returns false, if there is no such preset.
+func KeyPreset(name string) ([]Key, bool) {
+	keys, ok := keyPresets[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, false
+	}
+	return append([]Key(nil), keys...), true
+}
+
+// Formats the given keys as comma-separated list, which can be read using ParseKeys.
+func FormatKeys(keys []Key) string {
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = string(key)
+	}
+	return strings.Join(names, ", ")
+}
+
+// List of keys within the configuration.
+// The JSON representation may contain key names, Windows virtual-key codes and preset names.
+type KeyList []Key
+
+// Unmarshals a key list from JSON, replacing presets by their keys.
+func (t *KeyList) UnmarshalJSON(data []byte) error {
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	if entries == nil {
+		return nil
+	}
+	ret := make(KeyList, 0, len(entries))
+	for _, entry := range entries {
+		var name string
+		if json.Unmarshal(entry, &name) == nil {
+			if keys, ok := KeyPreset(name); ok {
+				ret = append(ret, keys...)
+				continue
+			}
+		}
+		var key Key
+		if err := json.Unmarshal(entry, &key); err != nil {
+			return err
+		}
+		ret = append(ret, key)
+	}
+	*t = ret
+	return nil
+}
+
+// Inspection of the key vocabulary.
+// Supported actions: list [preset], lookup <name|code>
+func ManageKeys(args []string) {
+	if len(args) < 1 {
+		log.Fatal("Missing argument")
+	}
+
+	switch args[0] {
+	case "list":
+		if len(args) > 1 {
+			keys, ok := KeyPreset(args[1])
+			if !ok {
+				log.Fatal(fmt.Sprintf("Unknown preset '%s'", args[1]))
+			}
+			for _, key := range keys {
+				printKey(key)
+			}
+			return
+		}
+		fmt.Printf("%-20s %-8s %-8s %-10s %s\n", "Name", "Windows", "Linux", "HID", "Aliases")
+		for _, codes := range keyTable {
+			printKey(codes.Name)
+		}
+		presets := make([]string, 0, len(keyPresets))
+		for name := range keyPresets {
+			presets = append(presets, name)
+		}
+		sort.Strings(presets)
+		fmt.Println()
+		fmt.Println("Presets:", strings.Join(presets, ", "))
+	case "lookup":
+		if len(args) < 2 {
+			log.Fatal("Missing key name or code")
+		}
+		name := strings.Join(args[1:], " ")
+		found := false
+		if keys, ok := KeyPreset(name); ok {
+			fmt.Printf("Preset '%s': %s\n", name, FormatKeys(keys))
+			found = true
+		}
+		if code, err := strconv.ParseInt(name, 0, 32); err == nil {
+			// Plain numbers may be Windows or Linux key codes.
+			if key := KeyFromWindows(int(code)); keysByName[key] != nil {
+				fmt.Printf("Windows code 0x%02X: ", code)
+				printKey(key)
+				found = true
+			}
+			if key := KeyFromLinux(int(code)); keysByName[key] != nil {
+				fmt.Printf("Linux code %d: ", code)
+				printKey(key)
+				found = true
+			}
+		} else if key, err := ParseKey(name); err == nil {
+			printKey(key)
+			found = true
+		}
+		if !found {
+			fmt.Printf("Unknown key '%s'\n", name)
+			os.Exit(1)
+		}
+	default:
+		log.Fatal("Unknown action")
+	}
+}
+
+// Prints the codes and aliases of the given key.
+func printKey(key Key) {
+	codes := func(code int, ok bool, format string) string {
+		if !ok {
+			return "-"
+		}
+		return fmt.Sprintf(format, code)
+	}
+	modifiers, base := key.Split()
+	windows, windowsOk := base.Windows()
+	linux, linuxOk := base.Linux()
+	hid, hidOk := base.HID()
+	aliases := make([]string, 0)
+	for alias, k := range keyAliases {
+		if k == base {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	if len(modifiers) > 0 {
+		aliases = append([]string{"modifiers: " + FormatKeys(modifiers)}, aliases...)
+	}
+	fmt.Printf("%-20s %-8s %-8s %-10s %s\n", key,
+		codes(windows, windowsOk, "0x%02X"),
+		codes(linux, linuxOk, "%d"),
+		codes(int(hid), hidOk, "0x%06X"),
+		strings.Join(aliases, ", "))
+}
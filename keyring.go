@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Secret and permissions of a single key ID.
+type _KeyringEntry struct {
+	name        string
+	encryption  Encryption
+	allowedKeys []Key
+	enabled     bool
+	validity    KeyGeneration
+}
+
+// Maps the key IDs of incoming packets to the secrets used for decryption.
+// The empty key ID refers to the server's default secret.
+type _Keyring struct {
+	entries map[string]*_KeyringEntry
+}
+
+// Create a new keyring using the default secret, the key generations and the client
+// entries of the given server configuration.
+// Secrets, key generations and client entries without secret are left out, so their key
+// IDs are rejected.
+func NewKeyring(config *ServerConfiguration) *_Keyring {
+	ret := new(_Keyring)
+	ret.entries = make(map[string]*_KeyringEntry)
+	if entry := ret.add("", config.Secret, nil, true); entry != nil {
+		entry.validity.NotAfter = config.SecretNotAfter
+	}
+	for _, generation := range config.KeyGenerations {
+		if entry := ret.add(generation.ID, generation.Secret, nil, true); entry != nil {
+			entry.validity = generation
+		}
+	}
+	for _, client := range config.Clients {
+		ret.add(client.Name, client.Secret, client.AllowedKeys, client.Enabled)
+	}
+	return ret
+}
+
+// Adds an entry for the given key ID and secret.
+// Returns nil without adding an entry, if the secret is empty: the encryption key would
+// be derived from a publicly known value then.
+func (t *_Keyring) add(name string, secret []byte, allowedKeys []Key, enabled bool) *_KeyringEntry {
+	if len(secret) == 0 {
+		return nil
+	}
+	entry := new(_KeyringEntry)
+	entry.name = name
+	entry.encryption.Initialize(secret)
+	entry.allowedKeys = allowedKeys
+	entry.enabled = enabled
+	t.entries[name] = entry
+	return entry
+}
+
+// Returns the entry of the given key ID.
+// Returns an error in case the key ID is unknown, not valid at the moment or the client
+// is disabled.
+func (t *_Keyring) Get(keyID string) (*_KeyringEntry, error) {
+	entry, ok := t.entries[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID '%s'", keyID)
+	}
+	if !entry.enabled {
+		return nil, fmt.Errorf("client '%s' is disabled", keyID)
+	}
+	if !entry.validity.IsValid(time.Now()) {
+		return nil, fmt.Errorf("key ID '%s' is expired or not yet active", keyID)
+	}
+	return entry, nil
+}
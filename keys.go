@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Platform-neutral key identifier.
+// Keys are named after the physical key, following the "code" values of the W3C UI Events
+// specification (e.g. "KeyA", "ArrowUp", "MediaPlayPause"). Keys, which are not part of the
+// vocabulary, are identified by platform and key code (e.g. "Windows:255", "Linux:240").
+// Key combinations list their modifiers in canonical order, followed by the key
+// (e.g. "Control+Alt+KeyP").
+type Key string
+
+// Prefixes of platform-specific key identifiers.
+const (
+	KEY_PREFIX_WINDOWS = "Windows:"
+	KEY_PREFIX_LINUX   = "Linux:"
+)
+
+// Separator of the modifiers and the key of a key combination.
+const KEY_COMBINATION_SEPARATOR = "+"
+
+// Modifiers of key combinations in canonical order, each with the key pressed to emit it.
+var keyModifiers = []struct {
+	Name Key
+	Key  Key
+}{
+	{"Control", "ControlLeft"},
+	{"Shift", "ShiftLeft"},
+	{"Alt", "AltLeft"},
+	{"Meta", "MetaLeft"},
+}
+
+// Codes of a single key within the USB HID usage tables and on the supported platforms.
+// The HID usage consists of usage page (upper 16 bits) and usage ID (lower 16 bits).
+// Zero means the key has no code on the respective platform.
+type _KeyCodes struct {
+	Name    Key
+	HID     uint32
+	Windows int
+	Linux   int
+}
+
+func hidKeyboard(id uint32) uint32 { return 0x07<<16 | id }
+func hidConsumer(id uint32) uint32 { return 0x0C<<16 | id }
+
+// The key vocabulary.
+// Windows: virtual-key codes (http://msdn.microsoft.com/en-us/library/windows/desktop/dd375731(v=vs.85).aspx)
+// Linux: input event codes (linux/input-event-codes.h)
+var keyTable = []_KeyCodes{
+	{"KeyA", hidKeyboard(0x04), 0x41, 30},
+	{"KeyB", hidKeyboard(0x05), 0x42, 48},
+	{"KeyC", hidKeyboard(0x06), 0x43, 46},
+	{"KeyD", hidKeyboard(0x07), 0x44, 32},
+	{"KeyE", hidKeyboard(0x08), 0x45, 18},
+	{"KeyF", hidKeyboard(0x09), 0x46, 33},
+	{"KeyG", hidKeyboard(0x0A), 0x47, 34},
+	{"KeyH", hidKeyboard(0x0B), 0x48, 35},
+	{"KeyI", hidKeyboard(0x0C), 0x49, 23},
+	{"KeyJ", hidKeyboard(0x0D), 0x4A, 36},
+	{"KeyK", hidKeyboard(0x0E), 0x4B, 37},
+	{"KeyL", hidKeyboard(0x0F), 0x4C, 38},
+	{"KeyM", hidKeyboard(0x10), 0x4D, 50},
+	{"KeyN", hidKeyboard(0x11), 0x4E, 49},
+	{"KeyO", hidKeyboard(0x12), 0x4F, 24},
+	{"KeyP", hidKeyboard(0x13), 0x50, 25},
+	{"KeyQ", hidKeyboard(0x14), 0x51, 16},
+	{"KeyR", hidKeyboard(0x15), 0x52, 19},
+	{"KeyS", hidKeyboard(0x16), 0x53, 31},
+	{"KeyT", hidKeyboard(0x17), 0x54, 20},
+	{"KeyU", hidKeyboard(0x18), 0x55, 22},
+	{"KeyV", hidKeyboard(0x19), 0x56, 47},
+	{"KeyW", hidKeyboard(0x1A), 0x57, 17},
+	{"KeyX", hidKeyboard(0x1B), 0x58, 45},
+	{"KeyY", hidKeyboard(0x1C), 0x59, 21},
+	{"KeyZ", hidKeyboard(0x1D), 0x5A, 44},
+	{"Digit1", hidKeyboard(0x1E), 0x31, 2},
+	{"Digit2", hidKeyboard(0x1F), 0x32, 3},
+	{"Digit3", hidKeyboard(0x20), 0x33, 4},
+	{"Digit4", hidKeyboard(0x21), 0x34, 5},
+	{"Digit5", hidKeyboard(0x22), 0x35, 6},
+	{"Digit6", hidKeyboard(0x23), 0x36, 7},
+	{"Digit7", hidKeyboard(0x24), 0x37, 8},
+	{"Digit8", hidKeyboard(0x25), 0x38, 9},
+	{"Digit9", hidKeyboard(0x26), 0x39, 10},
+	{"Digit0", hidKeyboard(0x27), 0x30, 11},
+	{"Enter", hidKeyboard(0x28), 0x0D, 28},
+	{"Escape", hidKeyboard(0x29), 0x1B, 1},
+	{"Backspace", hidKeyboard(0x2A), 0x08, 14},
+	{"Tab", hidKeyboard(0x2B), 0x09, 15},
+	{"Space", hidKeyboard(0x2C), 0x20, 57},
+	{"Minus", hidKeyboard(0x2D), 0xBD, 12},
+	{"Equal", hidKeyboard(0x2E), 0xBB, 13},
+	{"BracketLeft", hidKeyboard(0x2F), 0xDB, 26},
+	{"BracketRight", hidKeyboard(0x30), 0xDD, 27},
+	{"Backslash", hidKeyboard(0x31), 0xDC, 43},
+	{"Semicolon", hidKeyboard(0x33), 0xBA, 39},
+	{"Quote", hidKeyboard(0x34), 0xDE, 40},
+	{"Backquote", hidKeyboard(0x35), 0xC0, 41},
+	{"Comma", hidKeyboard(0x36), 0xBC, 51},
+	{"Period", hidKeyboard(0x37), 0xBE, 52},
+	{"Slash", hidKeyboard(0x38), 0xBF, 53},
+	{"CapsLock", hidKeyboard(0x39), 0x14, 58},
+	{"F1", hidKeyboard(0x3A), 0x70, 59},
+	{"F2", hidKeyboard(0x3B), 0x71, 60},
+	{"F3", hidKeyboard(0x3C), 0x72, 61},
+	{"F4", hidKeyboard(0x3D), 0x73, 62},
+	{"F5", hidKeyboard(0x3E), 0x74, 63},
+	{"F6", hidKeyboard(0x3F), 0x75, 64},
+	{"F7", hidKeyboard(0x40), 0x76, 65},
+	{"F8", hidKeyboard(0x41), 0x77, 66},
+	{"F9", hidKeyboard(0x42), 0x78, 67},
+	{"F10", hidKeyboard(0x43), 0x79, 68},
+	{"F11", hidKeyboard(0x44), 0x7A, 87},
+	{"F12", hidKeyboard(0x45), 0x7B, 88},
+	{"PrintScreen", hidKeyboard(0x46), 0x2C, 99},
+	{"ScrollLock", hidKeyboard(0x47), 0x91, 70},
+	{"Pause", hidKeyboard(0x48), 0x13, 119},
+	{"Insert", hidKeyboard(0x49), 0x2D, 110},
+	{"Home", hidKeyboard(0x4A), 0x24, 102},
+	{"PageUp", hidKeyboard(0x4B), 0x21, 104},
+	{"Delete", hidKeyboard(0x4C), 0x2E, 111},
+	{"End", hidKeyboard(0x4D), 0x23, 107},
+	{"PageDown", hidKeyboard(0x4E), 0x22, 109},
+	{"ArrowRight", hidKeyboard(0x4F), 0x27, 106},
+	{"ArrowLeft", hidKeyboard(0x50), 0x25, 105},
+	{"ArrowDown", hidKeyboard(0x51), 0x28, 108},
+	{"ArrowUp", hidKeyboard(0x52), 0x26, 103},
+	{"NumLock", hidKeyboard(0x53), 0x90, 69},
+	{"NumpadDivide", hidKeyboard(0x54), 0x6F, 98},
+	{"NumpadMultiply", hidKeyboard(0x55), 0x6A, 55},
+	{"NumpadSubtract", hidKeyboard(0x56), 0x6D, 74},
+	{"NumpadAdd", hidKeyboard(0x57), 0x6B, 78},
+	{"NumpadEnter", hidKeyboard(0x58), 0, 96},
+	{"Numpad1", hidKeyboard(0x59), 0x61, 79},
+	{"Numpad2", hidKeyboard(0x5A), 0x62, 80},
+	{"Numpad3", hidKeyboard(0x5B), 0x63, 81},
+	{"Numpad4", hidKeyboard(0x5C), 0x64, 75},
+	{"Numpad5", hidKeyboard(0x5D), 0x65, 76},
+	{"Numpad6", hidKeyboard(0x5E), 0x66, 77},
+	{"Numpad7", hidKeyboard(0x5F), 0x67, 71},
+	{"Numpad8", hidKeyboard(0x60), 0x68, 72},
+	{"Numpad9", hidKeyboard(0x61), 0x69, 73},
+	{"Numpad0", hidKeyboard(0x62), 0x60, 82},
+	{"NumpadDecimal", hidKeyboard(0x63), 0x6E, 83},
+	{"ContextMenu", hidKeyboard(0x65), 0x5D, 127},
+	{"F13", hidKeyboard(0x68), 0x7C, 183},
+	{"F14", hidKeyboard(0x69), 0x7D, 184},
+	{"F15", hidKeyboard(0x6A), 0x7E, 185},
+	{"F16", hidKeyboard(0x6B), 0x7F, 186},
+	{"F17", hidKeyboard(0x6C), 0x80, 187},
+	{"F18", hidKeyboard(0x6D), 0x81, 188},
+	{"F19", hidKeyboard(0x6E), 0x82, 189},
+	{"F20", hidKeyboard(0x6F), 0x83, 190},
+	{"F21", hidKeyboard(0x70), 0x84, 191},
+	{"F22", hidKeyboard(0x71), 0x85, 192},
+	{"F23", hidKeyboard(0x72), 0x86, 193},
+	{"F24", hidKeyboard(0x73), 0x87, 194},
+	{"ControlLeft", hidKeyboard(0xE0), 0xA2, 29},
+	{"ShiftLeft", hidKeyboard(0xE1), 0xA0, 42},
+	{"AltLeft", hidKeyboard(0xE2), 0xA4, 56},
+	{"MetaLeft", hidKeyboard(0xE3), 0x5B, 125},
+	{"ControlRight", hidKeyboard(0xE4), 0xA3, 97},
+	{"ShiftRight", hidKeyboard(0xE5), 0xA1, 54},
+	{"AltRight", hidKeyboard(0xE6), 0xA5, 100},
+	{"MetaRight", hidKeyboard(0xE7), 0x5C, 126},
+	{"Control", 0, 0x11, 0},
+	{"Shift", 0, 0x10, 0},
+	{"Alt", 0, 0x12, 0},
+	{"MediaPlay", hidConsumer(0xB0), 0xFA, 207},
+	{"MediaPause", hidConsumer(0xB1), 0, 201},
+	{"MediaTrackNext", hidConsumer(0xB5), 0xB0, 163},
+	{"MediaTrackPrevious", hidConsumer(0xB6), 0xB1, 165},
+	{"MediaStop", hidConsumer(0xB7), 0xB2, 166},
+	{"MediaPlayPause", hidConsumer(0xCD), 0xB3, 164},
+	{"AudioVolumeMute", hidConsumer(0xE2), 0xAD, 113},
+	{"AudioVolumeUp", hidConsumer(0xE9), 0xAF, 115},
+	{"AudioVolumeDown", hidConsumer(0xEA), 0xAE, 114},
+	{"LaunchMail", hidConsumer(0x18A), 0xB4, 155},
+	{"LaunchMediaPlayer", hidConsumer(0x183), 0xB5, 226},
+	{"LaunchApp2", hidConsumer(0x192), 0xB7, 140},
+	{"BrowserSearch", hidConsumer(0x221), 0xAA, 217},
+	{"BrowserHome", hidConsumer(0x223), 0xAC, 172},
+	{"BrowserBack", hidConsumer(0x224), 0xA6, 158},
+	{"BrowserForward", hidConsumer(0x225), 0xA7, 159},
+	{"BrowserStop", hidConsumer(0x226), 0xA9, 128},
+	{"BrowserRefresh", hidConsumer(0x227), 0xA8, 173},
+	{"BrowserFavorites", hidConsumer(0x22A), 0xAB, 156},
+}
+
+var (
+	keysByName    = make(map[Key]*_KeyCodes)
+	keysByWindows = make(map[int]*_KeyCodes)
+	keysByLinux   = make(map[int]*_KeyCodes)
+)
+
+func init() {
+	for i := range keyTable {
+		codes := &keyTable[i]
+		keysByName[codes.Name] = codes
+		if codes.Windows != 0 {
+			keysByWindows[codes.Windows] = codes
+		}
+		if codes.Linux != 0 {
+			if _, ok := keysByLinux[codes.Linux]; !ok {
+				keysByLinux[codes.Linux] = codes
+			}
+		}
+	}
+}
+
+// Returns the key of the given Windows virtual-key code.
+func KeyFromWindows(vk int) Key {
+	if codes, ok := keysByWindows[vk]; ok {
+		return codes.Name
+	}
+	return Key(KEY_PREFIX_WINDOWS + strconv.Itoa(vk))
+}
+
+// Returns the key of the given Linux input event code.
+func KeyFromLinux(code int) Key {
+	if codes, ok := keysByLinux[code]; ok {
+		return codes.Name
+	}
+	return Key(KEY_PREFIX_LINUX + strconv.Itoa(code))
+}
+
+// Returns the Windows virtual-key code of the key.
+// Returns false, if the key has no virtual-key code.
+func (t Key) Windows() (int, bool) {
+	return t.platformCode(KEY_PREFIX_WINDOWS, func(codes *_KeyCodes) int { return codes.Windows })
+}
+
+// Returns the Linux input event code of the key.
+// Returns false, if the key has no input event code.
+func (t Key) Linux() (int, bool) {
+	return t.platformCode(KEY_PREFIX_LINUX, func(codes *_KeyCodes) int { return codes.Linux })
+}
+
+// Returns the USB HID usage (page and ID) of the key.
+// Returns false, if the key has no HID usage.
+func (t Key) HID() (uint32, bool) {
+	codes, ok := keysByName[t]
+	if !ok || codes.HID == 0 {
+		return 0, false
+	}
+	return codes.HID, true
+}
+
+// Returns true, if the key is part of the vocabulary or a valid platform-specific key.
+// Key combinations must consist of known modifiers and a valid key.
+func (t Key) IsValid() bool {
+	modifiers, key := t.Split()
+	for _, modifier := range modifiers {
+		if modifierKey(modifier) == "" {
+			return false
+		}
+	}
+	if _, ok := keysByName[key]; ok {
+		return true
+	}
+	_, ok := key.Windows()
+	if !ok {
+		_, ok = key.Linux()
+	}
+	return ok
+}
+
+// Splits a key combination into its modifiers and the key.
+// Plain keys are returned without modifiers.
+func (t Key) Split() ([]Key, Key) {
+	parts := strings.Split(string(t), KEY_COMBINATION_SEPARATOR)
+	modifiers := make([]Key, 0, len(parts)-1)
+	for _, part := range parts[:len(parts)-1] {
+		modifiers = append(modifiers, Key(part))
+	}
+	return modifiers, Key(parts[len(parts)-1])
+}
+
+// Returns the key combination of the given modifiers and key.
+// Modifiers are put into canonical order, duplicates are removed.
+func KeyCombination(modifiers []Key, key Key) Key {
+	parts := make([]string, 0, len(modifiers)+1)
+	for _, modifier := range keyModifiers {
+		for _, m := range modifiers {
+			if m == modifier.Name {
+				parts = append(parts, string(m))
+				break
+			}
+		}
+	}
+	return Key(strings.Join(append(parts, string(key)), KEY_COMBINATION_SEPARATOR))
+}
+
+// Returns the key to press for emitting the given modifier.
+// Returns an empty key, if the given key is no modifier.
+func modifierKey(modifier Key) Key {
+	for _, m := range keyModifiers {
+		if m.Name == modifier {
+			return m.Key
+		}
+	}
+	return ""
+}
+
+func (t Key) platformCode(prefix string, code func(*_KeyCodes) int) (int, bool) {
+	if codes, ok := keysByName[t]; ok {
+		return code(codes), code(codes) != 0
+	}
+	if strings.HasPrefix(string(t), prefix) {
+		ret, err := strconv.Atoi(string(t)[len(prefix):])
+		return ret, err == nil && ret > 0
+	}
+	return 0, false
+}
+
+// Unmarshals a key from JSON.
+// Besides key names (see ParseKey), plain numbers are accepted and treated as Windows
+// virtual-key codes, to stay compatible with configurations of older versions.
+func (t *Key) UnmarshalJSON(data []byte) error {
+	var vk int
+	if json.Unmarshal(data, &vk) == nil {
+		*t = KeyFromWindows(vk)
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("invalid key %s", string(data))
+	}
+	key, err := ParseKey(name)
+	if err != nil {
+		return err
+	}
+	*t = key
+	return nil
+}
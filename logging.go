@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Log levels, which may be selected using the --log-level flag.
+// "debug" writes every message, including the ones otherwise rate-limited. "error" writes
+// error messages only, e.g. failing commands and secrets, which couldn't be removed.
+const (
+	LOG_LEVEL_DEBUG = "debug"
+	LOG_LEVEL_INFO  = "info"
+	LOG_LEVEL_ERROR = "error"
+)
+
+// Current log level.
+var logLevel = LOG_LEVEL_INFO
+
+// Selects the given log level.
+func SetLogLevel(level string) error {
+	switch level {
+	case LOG_LEVEL_DEBUG, LOG_LEVEL_INFO, LOG_LEVEL_ERROR:
+		logLevel = level
+		return nil
+	default:
+		return fmt.Errorf("unknown log level '%s' (debug, info or error)", level)
+	}
+}
+
+// Returns true, if messages of the given level are written at the current log level.
+func logEnabled(level string) bool {
+	switch logLevel {
+	case LOG_LEVEL_DEBUG:
+		return true
+	case LOG_LEVEL_INFO:
+		return level != LOG_LEVEL_DEBUG
+	default:
+		return level == LOG_LEVEL_ERROR
+	}
+}
+
+// Writes the formatted message to the log at the given level.
+func logMessage(level string, format string, v ...interface{}) {
+	if logEnabled(level) {
+		log.Println(fmt.Sprintf(format, v...))
+	}
+}
+
+// Writes the formatted error message to the log. Errors are written at every log level.
+func logError(format string, v ...interface{}) {
+	logMessage(LOG_LEVEL_ERROR, format, v...)
+}
+
+// Writes the formatted message to the log, unless the log level is "error".
+func logInfo(format string, v ...interface{}) {
+	logMessage(LOG_LEVEL_INFO, format, v...)
+}
+
+// Writes the formatted message to the log, if the log level is "debug".
+func logDebug(format string, v ...interface{}) {
+	logMessage(LOG_LEVEL_DEBUG, format, v...)
+}
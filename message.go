@@ -1,5 +1,63 @@
 package main
 
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Message types. Key messages leave the type empty, to stay compatible with older servers.
+const (
+	MESSAGE_KEY       = ""
+	MESSAGE_HEARTBEAT = "heartbeat"
+	MESSAGE_COVER     = "cover"
+	MESSAGE_ACK       = "ack"
+)
+
+// Message sent from the client to the server.
+// Key messages carry the name of the platform-neutral key. The name is resolved by the
+// server after decryption (see PressedKey), so names unknown to the server don't make the
+// message unreadable. The Windows virtual-key code is sent along for servers of older
+// versions.
+// Key messages with an 'Ack' token are acknowledged by the server, using an ack message
+// carrying the same token. 'Error' holds the reason, if the key was not emitted.
 type Message struct {
 	VkCode int
+	Key    string `json:",omitempty"`
+	Device string `json:",omitempty"`
+	Type   string `json:",omitempty"`
+	Ack    string `json:",omitempty"`
+	Error  string `json:",omitempty"`
+}
+
+// Returns the key of a key message.
+// Messages of older clients only contain the Windows virtual-key code. Returns an error, if
+// the key name is unknown.
+func (t Message) PressedKey() (Key, error) {
+	if len(t.Key) > 0 {
+		return ParseKey(t.Key)
+	}
+	return KeyFromWindows(t.VkCode), nil
+}
+
+// Size, the acknowledgements of the server are padded to.
+const ACK_MESSAGE_SIZE = 256
+
+// Encodes the given message as JSON.
+// If the given size is greater than zero, the data is padded with whitespace to exactly
+// this size. This way, all messages share the same length, regardless of their type, key
+// and device name.
+// Returns an error, if the message doesn't fit into the given size.
+func EncodeMessage(msg Message, size int) ([]byte, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	if size <= 0 {
+		return data, nil
+	}
+	if len(data) > size {
+		return nil, fmt.Errorf("message of %d bytes exceeds the packet size of %d bytes", len(data), size)
+	}
+	return append(data, bytes.Repeat([]byte(" "), size-len(data))...), nil
 }
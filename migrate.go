@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Upgrade of the configuration file from one schema version to the next.
+// Migrations operate on the top-level members of the file, so they don't depend on the
+// current configuration types.
+type _ConfigMigration struct {
+	From        int
+	Description string
+	Apply       func(document map[string]json.RawMessage) error
+}
+
+// All migrations of the configuration file, ordered by version.
+// Add a migration here along with each increment of CONFIG_SCHEMA_VERSION.
+var configMigrations = []_ConfigMigration{
+	{
+		// Files written before the schema version was introduced have the same layout.
+		From:        0,
+		Description: "add the schema version",
+		Apply:       func(document map[string]json.RawMessage) error { return nil },
+	},
+	{
+		// The configuration of version 1 becomes the default profile.
+		From:        1,
+		Description: "move the configuration to the default profile",
+		Apply: func(document map[string]json.RawMessage) error {
+			profile := make(map[string]json.RawMessage)
+			for _, role := range []string{"Client", "Server"} {
+				if raw, ok := document[role]; ok {
+					profile[role] = raw
+					delete(document, role)
+				}
+			}
+			profiles := make(map[string]interface{})
+			if len(profile) > 0 {
+				profiles[DEFAULT_PROFILE] = profile
+			}
+			var err error
+			document["Profiles"], err = json.Marshal(profiles)
+			return err
+		},
+	},
+}
+
+// Upgrades the given configuration file contents to CONFIG_SCHEMA_VERSION.
+// Returns the descriptions of the applied migrations, or an error in case the file was
+// written by a newer version of the program.
+func migrateConfigDocument(document map[string]json.RawMessage) ([]string, error) {
+	version := 0
+	if raw, ok := document["SchemaVersion"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return nil, fmt.Errorf("%w: SchemaVersion: %s", ErrConfigurationUnreadable, err)
+		}
+	}
+	if version > CONFIG_SCHEMA_VERSION {
+		return nil, fmt.Errorf("%w: schema version %d is newer than the supported version %d",
+			ErrConfigurationUnreadable, version, CONFIG_SCHEMA_VERSION)
+	}
+	applied := make([]string, 0)
+	for _, migration := range configMigrations {
+		if migration.From < version || migration.From >= CONFIG_SCHEMA_VERSION {
+			continue
+		}
+		if err := migration.Apply(document); err != nil {
+			return nil, fmt.Errorf("%w: migration from schema version %d: %s", ErrConfigurationUnreadable, migration.From, err)
+		}
+		applied = append(applied, fmt.Sprintf("schema version %d -> %d: %s", migration.From, migration.From+1, migration.Description))
+		version = migration.From + 1
+	}
+	document["SchemaVersion"], _ = json.Marshal(CONFIG_SCHEMA_VERSION)
+	return applied, nil
+}
+
+// Moves the configuration of all profiles from the Windows registry and credential store
+// to the configuration file and upgrades the file to the current schema version.
+// Roles, which already exist in the file, are left alone, so the migration may be run
+// repeatedly.
+// Supported flags: -dry-run (show the changes only) and -secret-store (secret storage to
+// move the secrets to)
+func MigrateConfiguration(args []string) {
+	flags := flag.NewFlagSet("config migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "show the changes without applying them")
+	secretStore := flags.String("secret-store", "", "secret storage to move the secrets to: wincred, secret-service or file")
+	flags.Parse(args)
+
+	path, err := ConfigFilePath()
+	if err != nil {
+		log.Fatal(err)
+	}
+	target := &_FileStore{path: path}
+	before, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	var steps []string
+	if len(before) > 0 {
+		var document map[string]json.RawMessage
+		if err := json.Unmarshal(before, &document); err != nil {
+			log.Fatal(fmt.Errorf("%w: %s", ErrConfigurationUnreadable, err))
+		}
+		if steps, err = migrateConfigDocument(document); err != nil {
+			log.Fatal(err)
+		}
+	}
+	file, err := target.read()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Configurations to move from the registry, along with their secret storage before.
+	var moved []*_MigratedProfile
+	if source, ok := registryConfigStore(DEFAULT_PROFILE); ok {
+		if name := source.DefaultProfile(); len(name) > 0 && len(file.DefaultProfile) == 0 {
+			file.DefaultProfile = name
+			steps = append(steps, fmt.Sprintf("default profile: %s", name))
+		}
+		for _, name := range source.Profiles() {
+			profile, profileSteps := migrateRegistryProfile(file, name, *secretStore)
+			steps = append(steps, profileSteps...)
+			if profile != nil {
+				moved = append(moved, profile)
+			}
+		}
+	}
+
+	if len(before) == 0 && len(moved) == 0 {
+		fmt.Println("Nothing to migrate")
+		return
+	}
+	after, err := renderConfigFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if bytes.Equal(before, after) {
+		fmt.Printf("%s is up to date\n", path)
+		for _, step := range steps {
+			fmt.Println(step)
+		}
+		return
+	}
+	for _, step := range steps {
+		fmt.Println(step)
+	}
+	if *dryRun {
+		fmt.Printf("--- %s\n+++ %s (migrated)\n", path, path)
+		for _, line := range diffLines(string(before), string(after)) {
+			fmt.Println(line)
+		}
+		return
+	}
+
+	// Secrets are written before the file, so a failure leaves the file untouched.
+	for _, profile := range moved {
+		if profile.client != nil {
+			if err := storeClientSecret(profile.client); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if profile.server != nil {
+			if err := storeServerSecrets(profile.server); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if err := target.write(file); err != nil {
+		log.Fatal(err)
+	}
+	for _, profile := range moved {
+		profile.removePreviousSecrets()
+	}
+	fmt.Printf("Configuration written to %s\n", path)
+}
+
+// Configuration of a profile moved from the registry to the configuration file.
+type _MigratedProfile struct {
+	client      *ClientConfiguration
+	clientStore string
+	server      *ServerConfiguration
+	serverStore string
+}
+
+// Adds the configuration of the given registry profile to the configuration file.
+// Roles, which already exist in the file, are skipped. The secrets are going to be moved to
+// the given secret storage, if any.
+// Returns the moved configuration (nil, if nothing was moved) and the migration steps.
+func migrateRegistryProfile(file *_ConfigFile, name string, secretStore string) (*_MigratedProfile, []string) {
+	source, _ := registryConfigStore(name)
+	target := file.profile(name)
+	ret := new(_MigratedProfile)
+	steps := make([]string, 0)
+	var err error
+	if source.ClientConfigurationExists() {
+		if len(target.Client) > 0 {
+			steps = append(steps, fmt.Sprintf("%s/client: already in the configuration file, skipped", name))
+		} else {
+			ret.client, err = source.LoadClientConfiguration()
+			if errors.Is(err, ErrConfigurationUnreadable) {
+				log.Fatal(err)
+			}
+			ret.clientStore = ret.client.SecretStore
+			if len(secretStore) > 0 {
+				ret.client.SecretStore = secretStore
+			}
+			ret.client.SecretStore = normalizeSecretStore(ret.client.SecretStore)
+			if target.Client, err = json.Marshal(ret.client); err != nil {
+				log.Fatal(err)
+			}
+			steps = append(steps, fmt.Sprintf("%s/client: move from %s", name, source.Location()))
+			steps = append(steps, describeSecretMove(name+"/client", ret.clientStore, ret.client.SecretStore))
+		}
+	}
+	if source.ServerConfigurationExists() {
+		if len(target.Server) > 0 {
+			steps = append(steps, fmt.Sprintf("%s/server: already in the configuration file, skipped", name))
+		} else {
+			ret.server, err = source.LoadServerConfiguration()
+			if errors.Is(err, ErrConfigurationUnreadable) {
+				log.Fatal(err)
+			}
+			ret.serverStore = ret.server.SecretStore
+			if len(secretStore) > 0 {
+				ret.server.SecretStore = secretStore
+			}
+			ret.server.SecretStore = normalizeSecretStore(ret.server.SecretStore)
+			if target.Server, err = json.Marshal(ret.server); err != nil {
+				log.Fatal(err)
+			}
+			steps = append(steps, fmt.Sprintf("%s/server: move from %s", name, source.Location()))
+			steps = append(steps, describeSecretMove(name+"/server", ret.serverStore, ret.server.SecretStore))
+		}
+	}
+	if ret.client == nil && ret.server == nil {
+		return nil, steps
+	}
+	return ret, steps
+}
+
+// Removes the secrets from the secret storage used before the migration, if it changed.
+func (t *_MigratedProfile) removePreviousSecrets() {
+	if t.client != nil && normalizeSecretStore(t.clientStore) != normalizeSecretStore(t.client.SecretStore) {
+		previous := *t.client
+		previous.SecretStore = t.clientStore
+		if err := deleteClientSecret(&previous); err != nil {
+			logError("Unable to remove the client secret from %s: %s", normalizeSecretStore(t.clientStore), err)
+		}
+	}
+	if t.server != nil && normalizeSecretStore(t.serverStore) != normalizeSecretStore(t.server.SecretStore) {
+		previous := *t.server
+		previous.SecretStore = t.serverStore
+		if err := deleteServerSecrets(&previous); err != nil {
+			logError("Unable to remove the server secrets from %s: %s", normalizeSecretStore(t.serverStore), err)
+		}
+	}
+}
+
+// Returns the name of the given secret storage, replacing the empty name by the default.
+func normalizeSecretStore(name string) string {
+	if len(name) == 0 {
+		return defaultSecretStore()
+	}
+	return name
+}
+
+// Describes, where the secrets of the given role are going to be stored.
+func describeSecretMove(role string, from string, to string) string {
+	from, to = normalizeSecretStore(from), normalizeSecretStore(to)
+	if from == to {
+		return fmt.Sprintf("%s: secrets stay in %s", role, from)
+	}
+	return fmt.Sprintf("%s: move secrets from %s to %s", role, from, to)
+}
+
+// Compares the lines of the given texts.
+// Returns all lines of both texts, prefixed by "-" (removed), "+" (added) or " " (unchanged).
+func diffLines(before string, after string) []string {
+	a := strings.Split(strings.TrimSuffix(before, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(after, "\n"), "\n")
+	if len(before) == 0 {
+		a = nil
+	}
+	// Length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	ret := make([]string, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ret = append(ret, " "+a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ret = append(ret, "-"+a[i])
+			i++
+		default:
+			ret = append(ret, "+"+b[j])
+			j++
+		}
+	}
+	return ret
+}
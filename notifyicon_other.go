@@ -0,0 +1,42 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"sync"
+)
+
+// Icons of the notify icon. Unused on platforms without notify icon.
+const (
+	IconClient = iota
+	IconServer
+)
+
+// Replacement of the notify icon on platforms without one.
+// Start just blocks until Stop() was called, the OnClick channel never fires.
+type _NotifyIcon struct {
+	onClick chan NotifyIconButton
+	stop    chan bool
+	once    sync.Once
+}
+
+func NewNotifyIcon(tooltip string, icon int) (*_NotifyIcon, error) {
+	ret := new(_NotifyIcon)
+	ret.onClick = make(chan NotifyIconButton)
+	ret.stop = make(chan bool)
+	return ret, nil
+}
+
+func (t *_NotifyIcon) Start() error {
+	<-t.stop
+	return nil
+}
+
+func (t *_NotifyIcon) Stop() {
+	t.once.Do(func() { close(t.stop) })
+}
+
+func (t *_NotifyIcon) OnClick() chan NotifyIconButton {
+	return t.onClick
+}
@@ -1,9 +1,10 @@
+//go:build windows
 // +build windows
+
 package main
 
 import (
 	"github.com/AllenDang/w32"
-	"log"
 	"syscall"
 	"unsafe"
 )
@@ -19,19 +20,12 @@ var (
 	IconServer    w32.HICON       = getIconHandle(NotifyIconData, niIconEntries[1])
 )
 
-// Type used for tracking interaction with the notify icon
-type NotifyIconButton int
-
-const (
-	LeftMouseButton NotifyIconButton = iota
-	RightMouseButton
-)
-
 type _NotifyIcon struct {
-	nid     _NOTIFYICONDATA
-	hwnd    w32.HWND
-	tooltip string
-	onClick chan NotifyIconButton
+	nid      _NOTIFYICONDATA
+	hwnd     w32.HWND
+	tooltip  string
+	onClick  chan NotifyIconButton
+	threadId uint32
 }
 
 // Create a new notify icon with the given tooltip and icon handle
@@ -42,17 +36,19 @@ func NewNotifyIcon(tooltip string, iconHandle w32.HICON) (*_NotifyIcon, error) {
 	ret.tooltip = tooltip
 	ret.nid.HIcon = iconHandle
 	ret.onClick = make(chan NotifyIconButton)
+	ret.threadId = getCurrentThreadId()
 	err := ret.createCallbackWindow()
 	return ret, err
 }
 
 // Adds the notification icon and starts handling the mouse
 // interaction with the icon.
+// Must be called from the thread, which created the notify icon (the main thread).
 // Calls to this function will block until the Stop() function
 // will be called from another goroutine/thread.
 // Returns an error, if adding the notify icons fails.
 func (t *_NotifyIcon) Start() (err error) {
-	log.Println("Creating notification icon")
+	logInfo("Creating notification icon")
 
 	tooltipUtf16, _ := syscall.UTF16FromString(t.tooltip)
 	t.nid.CbSize = w32.DWORD(unsafe.Sizeof(&t.nid))
@@ -67,7 +63,7 @@ func (t *_NotifyIcon) Start() (err error) {
 	}
 
 	var msg w32.MSG
-	for w32.GetMessage(&msg, t.hwnd, uint32(0), uint32(0)) != 0 {
+	for w32.GetMessage(&msg, 0, uint32(0), uint32(0)) != 0 {
 		w32.TranslateMessage(&msg)
 		w32.DispatchMessage(&msg)
 	}
@@ -76,12 +72,12 @@ func (t *_NotifyIcon) Start() (err error) {
 
 // Stops handling the interaction with the notify icon and
 // removes the icon.
-// The WM_QUIT message will be sent to all waiting GetMessage loops inside this process.
+// The WM_QUIT message will be sent to the GetMessage loop of the notify icon's thread.
 func (t *_NotifyIcon) Stop() {
-	log.Println("Removig notification icon")
+	logInfo("Removig notification icon")
 	shellNotifyIcon(_NIM_DELETE, &t.nid)
 	w32.DestroyIcon(t.nid.HIcon)
-	w32.PostQuitMessage(0)
+	postThreadQuit(t.threadId)
 }
 
 // Returns a channel object, which will be filled on left- or right click on the
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Packet layout:
+//
+//	magic "KF" | version (1 byte) | key ID length (1 byte) | key ID | IV | encrypted message
+//
+// The key ID selects the secret the server uses to decrypt the message. Packets without
+// the header (sent by older clients) are decrypted using the server's default secret.
+const (
+	PACKET_MAGIC      = "KF"
+	PACKET_VERSION    = 1
+	PACKET_MAX_KEY_ID = 255
+	PACKET_MAX_SIZE   = 1024
+)
+
+// Errors returned when decoding malformed packets.
+var (
+	ErrPacketTruncated = errors.New("truncated packet")
+	ErrPacketOversized = errors.New("oversized packet")
+	ErrPacketVersion   = errors.New("unsupported packet version")
+	ErrPacketKeyID     = errors.New("invalid key ID")
+	ErrPacketWrongKey  = errors.New("packet encrypted with wrong key")
+)
+
+// Prepends the packet header, including the given key ID, to the given encrypted message.
+func EncodePacket(keyID string, payload []byte) []byte {
+	if len(keyID) > PACKET_MAX_KEY_ID {
+		keyID = keyID[:PACKET_MAX_KEY_ID]
+	}
+	ret := make([]byte, 0, len(PACKET_MAGIC)+2+len(keyID)+len(payload))
+	ret = append(ret, PACKET_MAGIC...)
+	ret = append(ret, PACKET_VERSION, byte(len(keyID)))
+	ret = append(ret, keyID...)
+	return append(ret, payload...)
+}
+
+// Splits the given packet into key ID and encrypted message.
+// Packets without header are returned as they are, using the empty key ID.
+// Returns ErrPacketOversized, ErrPacketTruncated or ErrPacketVersion for malformed packets.
+func DecodePacket(data []byte) (keyID string, payload []byte, err error) {
+	if len(data) > PACKET_MAX_SIZE {
+		return "", nil, ErrPacketOversized
+	}
+	if len(data) < len(PACKET_MAGIC) || string(data[:len(PACKET_MAGIC)]) != PACKET_MAGIC {
+		return "", data, nil
+	}
+	headerSize := len(PACKET_MAGIC) + 2
+	if len(data) < headerSize {
+		return "", nil, ErrPacketTruncated
+	}
+	if data[len(PACKET_MAGIC)] != PACKET_VERSION {
+		return "", nil, fmt.Errorf("%w %d", ErrPacketVersion, data[len(PACKET_MAGIC)])
+	}
+	keyIDSize := int(data[len(PACKET_MAGIC)+1])
+	if len(data) < headerSize+keyIDSize {
+		return "", nil, ErrPacketTruncated
+	}
+	return string(data[headerSize : headerSize+keyIDSize]), data[headerSize+keyIDSize:], nil
+}
+
+// Decodes the given packet and decrypts the contained message using the secret of the
+// packet's key ID.
+// Never panics on malformed input. Returns one of the ErrPacket* errors (possibly wrapped)
+// in case the packet can't be decoded.
+func ParsePacket(data []byte, keyring *_Keyring) (*_KeyringEntry, Message, error) {
+	var msg Message
+	keyID, payload, err := DecodePacket(data)
+	if err != nil {
+		return nil, msg, err
+	}
+	entry, err := keyring.Get(keyID)
+	if err != nil {
+		return nil, msg, fmt.Errorf("%w: %s", ErrPacketKeyID, err)
+	}
+	plain, err := entry.encryption.Decrypt(payload)
+	if err != nil {
+		return entry, msg, err
+	}
+	if json.Unmarshal(plain, &msg) != nil {
+		return entry, msg, ErrPacketWrongKey
+	}
+	return entry, msg, nil
+}
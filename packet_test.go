@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// Returns a keyring with a default secret and a client entry, as used by the fuzz targets.
+func newTestKeyring() *_Keyring {
+	config := NewServerConfiguration()
+	config.Secret = []byte("default secret")
+	config.Clients = []ClientEntry{{Name: "laptop", Secret: []byte("client secret"), Enabled: true}}
+	return NewKeyring(config)
+}
+
+// Returns a packet of the given key message, encrypted using the given key ID and secret.
+func newTestPacket(keyID string, secret []byte, key Key) []byte {
+	var encryption Encryption
+	encryption.Initialize(secret)
+	data, err := EncodeMessage(keyMessage(key), GetDefaultTrafficConfiguration().PacketSize)
+	if err != nil {
+		panic(err)
+	}
+	return EncodePacket(keyID, encryption.Encrypt(data))
+}
+
+// Adds valid, truncated, oversized, wrong-version and wrong-key packets to the corpus.
+func addPacketSeeds(f *testing.F) {
+	valid := newTestPacket("", []byte("default secret"), "MediaPlayPause")
+	f.Add(valid)
+	f.Add(newTestPacket("laptop", []byte("client secret"), "AudioVolumeUp"))
+	f.Add(valid[:len(PACKET_MAGIC)+1])
+	f.Add([]byte(PACKET_MAGIC + "\x01\x05abc"))
+	f.Add(valid[:len(PACKET_MAGIC)+2+8])
+	f.Add(bytes.Repeat([]byte("K"), PACKET_MAX_SIZE+1))
+	f.Add(append([]byte(PACKET_MAGIC+"\x02\x00"), valid[len(PACKET_MAGIC)+2:]...))
+	f.Add(newTestPacket("", []byte("wrong secret"), "MediaPlayPause"))
+	f.Add(newTestPacket("unknown", []byte("client secret"), "MediaPlayPause"))
+	f.Add([]byte{})
+}
+
+// Returns true, if the given error is one of the ErrPacket* errors.
+func isPacketError(err error) bool {
+	for _, known := range []error{ErrPacketTruncated, ErrPacketOversized, ErrPacketVersion, ErrPacketKeyID, ErrPacketWrongKey} {
+		if errors.Is(err, known) {
+			return true
+		}
+	}
+	return false
+}
+
+func FuzzDecodePacket(f *testing.F) {
+	addPacketSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		keyID, payload, err := DecodePacket(data)
+		if err != nil {
+			if !errors.Is(err, ErrPacketTruncated) && !errors.Is(err, ErrPacketOversized) && !errors.Is(err, ErrPacketVersion) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return
+		}
+		if len(keyID) > PACKET_MAX_KEY_ID || len(payload) > len(data) {
+			t.Fatalf("invalid result: key ID of %d bytes, payload of %d bytes", len(keyID), len(payload))
+		}
+	})
+}
+
+func FuzzParsePacket(f *testing.F) {
+	addPacketSeeds(f)
+	keyring := newTestKeyring()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		entry, _, err := ParsePacket(data, keyring)
+		if err != nil {
+			if !isPacketError(err) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return
+		}
+		if entry == nil {
+			t.Fatal("missing keyring entry")
+		}
+	})
+}
+
+func TestParsePacket(t *testing.T) {
+	keyring := newTestKeyring()
+	tests := []struct {
+		name   string
+		packet []byte
+		keyID  string
+		err    error
+	}{
+		{"default secret", newTestPacket("", []byte("default secret"), "MediaPlayPause"), "", nil},
+		{"client secret", newTestPacket("laptop", []byte("client secret"), "MediaPlayPause"), "laptop", nil},
+		{"truncated", []byte(PACKET_MAGIC + "\x01\x05abc"), "", ErrPacketTruncated},
+		{"oversized", bytes.Repeat([]byte("K"), PACKET_MAX_SIZE+1), "", ErrPacketOversized},
+		{"version", []byte(PACKET_MAGIC + "\x02\x00"), "", ErrPacketVersion},
+		{"unknown key ID", newTestPacket("unknown", []byte("client secret"), "MediaPlayPause"), "", ErrPacketKeyID},
+		{"wrong key", newTestPacket("", []byte("wrong secret"), "MediaPlayPause"), "", ErrPacketWrongKey},
+	}
+	for _, test := range tests {
+		entry, msg, err := ParsePacket(test.packet, keyring)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: got error %v, expected %v", test.name, err, test.err)
+			continue
+		}
+		if test.err != nil {
+			continue
+		}
+		if key, err := msg.PressedKey(); entry.name != test.keyID || key != "MediaPlayPause" {
+			t.Errorf("%s: got key ID '%s' and key %s (%v)", test.name, entry.name, key, err)
+		}
+	}
+}
+
+func TestParsePacketUnknownKey(t *testing.T) {
+	packet := newTestPacket("", []byte("default secret"), "FutureKey")
+	_, msg, err := ParsePacket(packet, newTestKeyring())
+	if err != nil {
+		t.Fatalf("got error %v, expected the message to be decoded", err)
+	}
+	if key, err := msg.PressedKey(); err == nil {
+		t.Fatalf("got key %s, expected an unknown key", key)
+	}
+}
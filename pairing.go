@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mdp/qrterminal/v3"
+	"hash/crc32"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Prefix of pairing codes, including the format version.
+const PAIRING_PREFIX = "KEYFWD1:"
+
+// Environment variable holding the passphrase of pairing codes.
+const ENV_PAIRING_PASSPHRASE = "KEYFWD_PAIRING_PASSPHRASE"
+
+// Name of the encrypted settings within a pairing code, used as additional data.
+const PAIRING_SECRET = "danieljoos/keyfwd/pairing"
+
+// Length of the salt deriving the key of a pairing code.
+const PAIRING_SALT_SIZE = 16
+
+// Encoding of pairing codes. Upper case letters and digits fit the alphanumeric mode of
+// QR codes and can be typed without ambiguity.
+var pairingEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Settings of a client, transferred by a pairing code.
+// The short names keep the code compact.
+type _Pairing struct {
+	Host   string  `json:"h"`
+	Port   uint64  `json:"p"`
+	Secret []byte  `json:"s"`
+	KeyID  string  `json:"i,omitempty"`
+	Keys   KeyList `json:"k"`
+}
+
+// Returns the pairing settings for a client of the given server.
+// The client uses the secret of the client entry with the given name or, if no name is
+// given, the newest valid secret of the server: after a rotation, this is the latest key
+// generation, selected by its ID as key ID. The client forwards the keys permitted to it.
+func newPairing(configuration *ServerConfiguration, host string, clientName string) (*_Pairing, error) {
+	if len(host) == 0 {
+		return nil, errors.New("missing hostname of the server")
+	}
+	ret := &_Pairing{Host: host, Port: configuration.Port, Keys: compactKeyList(configuration.AllowedKeys)}
+	if len(clientName) == 0 {
+		ret.KeyID, ret.Secret = configuration.newestSecret(time.Now())
+	} else {
+		client := configuration.FindClient(clientName)
+		if client == nil {
+			return nil, fmt.Errorf("unknown client '%s'", clientName)
+		}
+		ret.Secret = client.Secret
+		ret.KeyID = client.Name
+		if client.AllowedKeys != nil {
+			ret.Keys = compactKeyList(client.AllowedKeys)
+		}
+	}
+	if len(ret.Secret) == 0 {
+		return nil, errors.New("no valid secret to pair with (rotate the secret or select a client entry using -pairing-client)")
+	}
+	return ret, nil
+}
+
+// Returns the name of the matching preset as only entry, if the given keys match a preset.
+// Otherwise, the keys are returned unchanged. Presets are expanded when reading a KeyList.
+func compactKeyList(keys KeyList) KeyList {
+	names := make([]string, 0, len(keyPresets))
+	for name := range keyPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if reflect.DeepEqual([]Key(keys), keyPresets[name]) {
+			return KeyList{Key(name)}
+		}
+	}
+	return keys
+}
+
+// Returns the pairing code of the given settings: the prefix, followed by the salt, the
+// settings encrypted by a key derived from the given passphrase and a CRC-32 checksum,
+// base32-encoded.
+func encodePairing(pairing *_Pairing, passphrase []byte) (string, error) {
+	data, err := json.Marshal(pairing)
+	if err != nil {
+		return "", err
+	}
+	salt := make([]byte, PAIRING_SALT_SIZE)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	aead, err := newPassphraseCipher(passphrase, salt, SECRET_FILE_ITERATIONS)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := sealSecret(aead, PAIRING_SECRET, data)
+	if err != nil {
+		return "", err
+	}
+	raw := append(salt, sealed...)
+	raw = binary.BigEndian.AppendUint32(raw, crc32.ChecksumIEEE(raw))
+	return PAIRING_PREFIX + pairingEncoding.EncodeToString(raw), nil
+}
+
+// Returns the settings of the given pairing code.
+// The checksum is verified before the passphrase is asked for, so typing errors are
+// reported as such.
+func decodePairing(code string, passphrase func() ([]byte, error)) (*_Pairing, error) {
+	code = strings.ToUpper(strings.Join(strings.Fields(code), ""))
+	if !strings.HasPrefix(code, PAIRING_PREFIX) {
+		return nil, errors.New("invalid pairing code (unknown format)")
+	}
+	raw, err := pairingEncoding.DecodeString(strings.ReplaceAll(strings.TrimPrefix(code, PAIRING_PREFIX), "-", ""))
+	if err != nil || len(raw) < PAIRING_SALT_SIZE+4 {
+		return nil, errors.New("invalid pairing code")
+	}
+	data, checksum := raw[:len(raw)-4], raw[len(raw)-4:]
+	if !bytes.Equal(binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE(data)), checksum) {
+		return nil, errors.New("invalid pairing code (checksum mismatch)")
+	}
+	key, err := passphrase()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newPassphraseCipher(key, data[:PAIRING_SALT_SIZE], SECRET_FILE_ITERATIONS)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := openSecret(aead, PAIRING_SECRET, data[PAIRING_SALT_SIZE:])
+	if err != nil {
+		return nil, errors.New("wrong passphrase for the pairing code")
+	}
+	ret := new(_Pairing)
+	if err := json.Unmarshal(plain, ret); err != nil {
+		return nil, fmt.Errorf("invalid pairing code: %s", err)
+	}
+	return ret, nil
+}
+
+// Prints the pairing code for a client of the given server, as text and as QR code.
+func ExportPairing(configuration *ServerConfiguration, host string, clientName string) error {
+	pairing, err := newPairing(configuration, host, clientName)
+	if err != nil {
+		return err
+	}
+	passphrase, err := getPassphrase(ENV_PAIRING_PASSPHRASE, "pairing code", true)
+	if err != nil {
+		return err
+	}
+	code, err := encodePairing(pairing, passphrase)
+	if err != nil {
+		return err
+	}
+	qrterminal.GenerateHalfBlock(code, qrterminal.L, os.Stdout)
+	fmt.Println(code)
+	fmt.Println()
+	fmt.Println("Configure the client using: keyfwd configure client -pairing <code>")
+	return nil
+}
+
+// Replaces the client settings with the settings of the given pairing code.
+func applyPairing(configuration *ClientConfiguration, code string) error {
+	pairing, err := decodePairing(code, func() ([]byte, error) {
+		return getPassphrase(ENV_PAIRING_PASSPHRASE, "pairing code", false)
+	})
+	if err != nil {
+		return err
+	}
+	configuration.Hostname = pairing.Host
+	configuration.Port = pairing.Port
+	configuration.Secret = pairing.Secret
+	configuration.KeyID = pairing.KeyID
+	configuration.ForwardedKeys = pairing.Keys
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewPairingAfterRotation(t *testing.T) {
+	now := time.Now()
+	configuration := NewServerConfiguration()
+	configuration.Secret = []byte("default")
+	configuration.SecretNotAfter = now.Add(time.Hour)
+	configuration.KeyGenerations = []KeyGeneration{
+		{ID: "key-1", Secret: []byte("first"), NotBefore: now.Add(-2 * time.Hour), NotAfter: now.Add(time.Hour)},
+		{ID: "key-2", Secret: []byte("second"), NotBefore: now.Add(-time.Hour)},
+		{ID: "key-3", Secret: []byte("future"), NotBefore: now.Add(time.Hour)},
+	}
+	pairing, err := newPairing(configuration, "target", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pairing.KeyID != "key-2" || !bytes.Equal(pairing.Secret, []byte("second")) {
+		t.Fatalf("got key ID '%s' with secret '%s', expected 'key-2' with 'second'", pairing.KeyID, pairing.Secret)
+	}
+}
+
+func TestNewPairingExpiredSecret(t *testing.T) {
+	configuration := NewServerConfiguration()
+	configuration.Secret = []byte("default")
+	configuration.SecretNotAfter = time.Now().Add(-time.Minute)
+	if _, err := newPairing(configuration, "target", ""); err == nil {
+		t.Fatal("paired with an expired secret")
+	}
+
+	configuration.SecretNotAfter = time.Time{}
+	pairing, err := newPairing(configuration, "target", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairing.KeyID) != 0 || !bytes.Equal(pairing.Secret, []byte("default")) {
+		t.Fatalf("got key ID '%s' with secret '%s', expected the default secret", pairing.KeyID, pairing.Secret)
+	}
+}
+
+// Returns the passphrase of pairing codes, as taken by 'configure client -pairing'.
+func pairingPassphrase() ([]byte, error) {
+	return getPassphrase(ENV_PAIRING_PASSPHRASE, "pairing code", false)
+}
+
+func TestPairingCodeRoundTrip(t *testing.T) {
+	t.Setenv(ENV_PAIRING_PASSPHRASE, "correct horse")
+	pairing := &_Pairing{Host: "target", Port: 9000, Secret: []byte("secret"), KeyID: "laptop", Keys: KeyList{"MediaPlayPause", "AudioVolumeUp"}}
+	passphrase, _ := pairingPassphrase()
+	code, err := encodePairing(pairing, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Split into groups and typed in lower case, as people copy codes.
+	body := strings.TrimPrefix(code, PAIRING_PREFIX)
+	groups := make([]string, 0)
+	for len(body) > 5 {
+		groups = append(groups, body[:5])
+		body = body[5:]
+	}
+	groups = append(groups, body)
+	typed := " " + strings.ToLower(PAIRING_PREFIX+strings.Join(groups[:2], "-")+"\n"+strings.Join(groups[2:], " ")) + " "
+
+	for _, input := range []string{code, typed} {
+		decoded, err := decodePairing(input, pairingPassphrase)
+		if err != nil {
+			t.Fatalf("%q: %v", input, err)
+		}
+		if !reflect.DeepEqual(decoded, pairing) {
+			t.Fatalf("got %+v, expected %+v", decoded, pairing)
+		}
+	}
+
+	t.Setenv(ENV_PAIRING_PASSPHRASE, "wrong")
+	if _, err := decodePairing(code, pairingPassphrase); err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Fatalf("got %v, expected a wrong passphrase", err)
+	}
+}
+
+func TestPairingCodeChecksum(t *testing.T) {
+	code, err := encodePairing(&_Pairing{Host: "target", Port: 9000, Secret: []byte("secret")}, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := len(PAIRING_PREFIX) + 10
+	replacement := "A"
+	if code[i] == 'A' {
+		replacement = "B"
+	}
+	tampered := code[:i] + replacement + code[i+1:]
+	_, err = decodePairing(tampered, func() ([]byte, error) {
+		t.Fatal("the passphrase was asked for, before the checksum was verified")
+		return nil, nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("got %v, expected a checksum mismatch", err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Name of the profile used, if no other profile was selected or set as default.
+// The default profile keeps the configuration and secrets of versions without profiles.
+const DEFAULT_PROFILE = "default"
+
+// Environment variable selecting the profile to use.
+const ENV_PROFILE = "KEYFWD_PROFILE"
+
+// Valid profile names. Profile names are part of registry keys, secret names and the
+// configuration file.
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// Profile selected by the --profile flag, see parseGlobalFlags.
+var selectedProfile string
+
+// Checks the given profile name.
+func ValidateProfileName(name string) error {
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid profile name '%s' (letters, digits, '.', '_' and '-', at most 64 characters)", name)
+	}
+	return nil
+}
+
+// Returns the profile to use: the profile selected by the --profile flag, the
+// KEYFWD_PROFILE environment variable or the stored default profile, in this order.
+func CurrentProfile() string {
+	name, _ := currentProfileOrigin()
+	return name
+}
+
+// Returns the profile to use (see CurrentProfile) and where it was selected:
+// "flag --profile", "env KEYFWD_PROFILE", the kind of the configuration storage holding
+// the default profile, or "default".
+// Profile names become part of registry keys and secret names, so the program exits, if
+// the selected name is invalid.
+func currentProfileOrigin() (string, string) {
+	if len(selectedProfile) > 0 {
+		return mustValidProfileName(selectedProfile, "--profile"), "flag --profile"
+	}
+	if name := os.Getenv(ENV_PROFILE); len(name) > 0 {
+		return mustValidProfileName(name, ENV_PROFILE), "env " + ENV_PROFILE
+	}
+	store := OpenConfigStore(DEFAULT_PROFILE)
+	if name := store.DefaultProfile(); len(name) > 0 {
+		return mustValidProfileName(name, "stored default profile"), store.Kind()
+	}
+	return DEFAULT_PROFILE, "default"
+}
+
+// Returns the given profile name or exits, if the name is invalid.
+func mustValidProfileName(name string, origin string) string {
+	if err := ValidateProfileName(name); err != nil {
+		log.Fatal(fmt.Sprintf("%s: %s", origin, err))
+	}
+	return name
+}
+
+// Management of the configuration profiles.
+// Supported actions: list, copy <from> <to>, delete <name>, default [name]
+func ManageProfiles(args []string) {
+	if len(args) < 1 {
+		log.Fatal("Missing argument")
+	}
+	store := OpenConfigStore(DEFAULT_PROFILE)
+
+	switch args[0] {
+	case "list":
+		current := CurrentProfile()
+		for _, name := range store.Profiles() {
+			profile := OpenConfigStore(name)
+			roles := make([]string, 0, 2)
+			if profile.ClientConfigurationExists() {
+				roles = append(roles, "client")
+			}
+			if profile.ServerConfigurationExists() {
+				roles = append(roles, "server")
+			}
+			marker := " "
+			if name == current {
+				marker = "*"
+			}
+			fmt.Printf("%s %-20s %s\n", marker, name, strings.Join(roles, ", "))
+		}
+	case "copy":
+		if len(args) < 3 {
+			log.Fatal("Missing profile name")
+		}
+		if err := CopyProfile(args[1], args[2]); err != nil {
+			log.Fatal(err)
+		}
+	case "delete":
+		if len(args) < 2 {
+			log.Fatal("Missing profile name")
+		}
+		if err := DeleteProfile(args[1]); err != nil {
+			log.Fatal(err)
+		}
+	case "default":
+		if len(args) < 2 {
+			name := store.DefaultProfile()
+			if len(name) == 0 {
+				name = DEFAULT_PROFILE
+			}
+			fmt.Println(name)
+			return
+		}
+		if err := ValidateProfileName(args[1]); err != nil {
+			log.Fatal(err)
+		}
+		if err := store.SetDefaultProfile(args[1]); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatal("Unknown profile action")
+	}
+}
+
+// Copies the configuration and the secrets of a profile to a new profile.
+func CopyProfile(from string, to string) error {
+	for _, name := range []string{from, to} {
+		if err := ValidateProfileName(name); err != nil {
+			return err
+		}
+	}
+	source, target := OpenConfigStore(from), OpenConfigStore(to)
+	if !source.ClientConfigurationExists() && !source.ServerConfigurationExists() {
+		return fmt.Errorf("unknown profile '%s'", from)
+	}
+	if target.ClientConfigurationExists() || target.ServerConfigurationExists() {
+		return fmt.Errorf("profile '%s' already exists", to)
+	}
+	if source.ClientConfigurationExists() {
+		configuration, err := source.LoadClientConfiguration()
+		if errors.Is(err, ErrConfigurationUnreadable) {
+			return err
+		}
+		if err := target.StoreClientConfiguration(configuration); err != nil {
+			return err
+		}
+	}
+	if source.ServerConfigurationExists() {
+		configuration, err := source.LoadServerConfiguration()
+		if errors.Is(err, ErrConfigurationUnreadable) {
+			return err
+		}
+		if err := target.StoreServerConfiguration(configuration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Removes the configuration and the secrets of the given profile.
+func DeleteProfile(name string) error {
+	if err := ValidateProfileName(name); err != nil {
+		return err
+	}
+	store := OpenConfigStore(name)
+	if !store.ClientConfigurationExists() && !store.ServerConfigurationExists() {
+		return fmt.Errorf("unknown profile '%s'", name)
+	}
+	// The configuration names the secrets to remove.
+	if store.ClientConfigurationExists() {
+		configuration, _ := store.LoadClientConfiguration()
+		if err := deleteClientSecret(configuration); err != nil {
+			logError("Unable to remove the client secret of profile '%s': %s", name, err)
+		}
+	}
+	if store.ServerConfigurationExists() {
+		configuration, _ := store.LoadServerConfiguration()
+		if err := deleteServerSecrets(configuration); err != nil {
+			logError("Unable to remove the server secrets of profile '%s': %s", name, err)
+		}
+	}
+	return store.DeleteProfile()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Policies for handling events pushed to a full queue.
+const (
+	DROP_OLDEST = "drop-oldest"
+	DROP_NEWEST = "drop-newest"
+	DROP_BLOCK  = "block"
+)
+
+// Bounded queue between two stages of the event pipeline (e.g. key capture and sending).
+// Events, which don't fit into the queue, are dropped according to the configured drop
+// policy. Each drop is counted using the queue's name as counter prefix.
+type _EventQueue struct {
+	name         string
+	policy       string
+	blockTimeout time.Duration
+	events       chan interface{}
+	counters     *_Counters
+	lock         sync.Mutex
+}
+
+// Create a new event queue using the given configuration.
+// The counters '<name>_enqueued', '<name>_dropped_oldest', '<name>_dropped_newest' and
+// '<name>_dropped_timeout' are maintained inside the given counters object.
+func NewEventQueue(name string, config QueueConfiguration, counters *_Counters) *_EventQueue {
+	ret := new(_EventQueue)
+	ret.name = name
+	ret.policy = config.DropPolicy
+	ret.blockTimeout = time.Duration(config.BlockTimeoutMilliseconds) * time.Millisecond
+	size := config.Size
+	if size < 1 {
+		size = 1
+	}
+	ret.events = make(chan interface{}, size)
+	ret.counters = counters
+	return ret
+}
+
+// Adds the given event to the queue.
+// Returns false, if the event or another, older event had to be dropped.
+func (t *_EventQueue) Push(event interface{}) bool {
+	select {
+	case t.events <- event:
+		t.counters.Inc(t.name + "_enqueued")
+		return true
+	default:
+	}
+
+	switch t.policy {
+	case DROP_NEWEST:
+		t.counters.Inc(t.name + "_dropped_newest")
+		return false
+	case DROP_BLOCK:
+		timer := time.NewTimer(t.blockTimeout)
+		defer timer.Stop()
+		select {
+		case t.events <- event:
+			t.counters.Inc(t.name + "_enqueued")
+			return true
+		case <-timer.C:
+			t.counters.Inc(t.name + "_dropped_timeout")
+			return false
+		}
+	}
+
+	// Drop the oldest events, until the new one fits.
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for {
+		select {
+		case t.events <- event:
+			t.counters.Inc(t.name + "_enqueued")
+			return false
+		default:
+		}
+		select {
+		case <-t.events:
+			t.counters.Inc(t.name + "_dropped_oldest")
+		default:
+		}
+	}
+}
+
+// Returns the channel to receive the queued events from.
+// The channel is never closed, as events may be pushed at any time (e.g. by the keyboard
+// hook). Receivers stop on their own, e.g. after draining the remaining events.
+func (t *_EventQueue) Events() <-chan interface{} {
+	return t.events
+}
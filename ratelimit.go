@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Errors returned for events exceeding a limit.
+var (
+	ErrSenderRateLimit = errors.New("sender rate limit exceeded")
+	ErrKeyRateLimit    = errors.New("key rate limit exceeded")
+	ErrGlobalRateLimit = errors.New("global rate limit exceeded")
+)
+
+// Idle time after which the buckets of a sender are discarded.
+const RATE_LIMIT_IDLE_TIMEOUT = 5 * time.Minute
+
+// Maximum number of buckets per kind (senders, senders and keys). Sender addresses are
+// spoofable and buckets are created before any authentication, so further senders share
+// a single bucket, until buckets get discarded.
+const RATE_LIMIT_MAX_BUCKETS = 4096
+
+// ID of the bucket shared by the senders exceeding RATE_LIMIT_MAX_BUCKETS.
+const RATE_LIMIT_OVERFLOW_ID = "\x00overflow"
+
+// Token bucket, refilled with 'rate' tokens per second up to a maximum of 'burst' tokens.
+// A rate of zero disables the bucket.
+type _TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *_TokenBucket {
+	ret := new(_TokenBucket)
+	ret.rate = rate
+	ret.burst = float64(burst)
+	if ret.burst < 1 {
+		ret.burst = 1
+	}
+	ret.tokens = ret.burst
+	ret.last = now
+	return ret
+}
+
+// Takes a token from the bucket.
+// Returns false, if the bucket is empty.
+func (t *_TokenBucket) allow(now time.Time) bool {
+	if t.rate <= 0 {
+		return true
+	}
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if t.tokens > t.burst {
+		t.tokens = t.burst
+	}
+	t.last = now
+	if t.tokens < 1 {
+		return false
+	}
+	t.tokens--
+	return true
+}
+
+// Returns true, if the bucket got refilled completely at the given time. Such a bucket
+// doesn't limit anything, so it may be discarded.
+func (t *_TokenBucket) isFull(now time.Time) bool {
+	return t.rate <= 0 || t.tokens+now.Sub(t.last).Seconds()*t.rate >= t.burst
+}
+
+// Limits the number of events per sender, per sender and key and in total.
+// Senders are banned temporarily after repeated decryption failures.
+type _RateLimiter struct {
+	config   RateLimitConfiguration
+	lock     sync.Mutex
+	global   *_TokenBucket
+	senders  map[string]*_TokenBucket
+	keys     map[string]*_TokenBucket
+	failures map[string]int
+	bans     map[string]time.Time
+	cleaned  time.Time
+	pruned   time.Time
+}
+
+// Create a new rate limiter using the given limits.
+func NewRateLimiter(config RateLimitConfiguration) *_RateLimiter {
+	now := time.Now()
+	ret := new(_RateLimiter)
+	ret.config = config
+	ret.global = newTokenBucket(config.GlobalRate, config.GlobalBurst, now)
+	ret.senders = make(map[string]*_TokenBucket)
+	ret.keys = make(map[string]*_TokenBucket)
+	ret.failures = make(map[string]int)
+	ret.bans = make(map[string]time.Time)
+	ret.cleaned = now
+	return ret
+}
+
+// Replaces the limits. The buckets start over, while failures and bans are kept.
+func (t *_RateLimiter) SetLimits(config RateLimitConfiguration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	t.config = config
+	t.global = newTokenBucket(config.GlobalRate, config.GlobalBurst, now)
+	t.senders = make(map[string]*_TokenBucket)
+	t.keys = make(map[string]*_TokenBucket)
+}
+
+// Returns true, if the given sender is currently banned.
+func (t *_RateLimiter) IsBanned(sender string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	until, ok := t.bans[sender]
+	if ok && time.Now().After(until) {
+		delete(t.bans, sender)
+		return false
+	}
+	return ok
+}
+
+// Records a decryption failure of the given sender.
+// Failures are only counted, if bans are enabled by a ban threshold greater than zero.
+// Returns true, if the sender got banned by this failure.
+func (t *_RateLimiter) RecordFailure(sender string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.config.BanThreshold <= 0 {
+		return false
+	}
+	t.failures[sender]++
+	if t.failures[sender] < t.config.BanThreshold {
+		return false
+	}
+	delete(t.failures, sender)
+	t.bans[sender] = time.Now().Add(time.Duration(t.config.BanSeconds) * time.Second)
+	return true
+}
+
+// Resets the decryption failures of the given sender.
+func (t *_RateLimiter) RecordSuccess(sender string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.failures, sender)
+}
+
+// Takes a token from the bucket of the given sender.
+// Returns an error in case the sender exceeded its limit.
+func (t *_RateLimiter) AllowSender(sender string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	t.cleanup(now)
+	bucket := t.bucket(t.senders, sender, t.config.SenderRate, t.config.SenderBurst, now)
+	if !bucket.allow(now) {
+		return ErrSenderRateLimit
+	}
+	return nil
+}
+
+// Returns the token taken by AllowSender to the bucket of the given sender.
+// Used for authenticated cover traffic and heartbeats, which the server can't tell from
+// keys before decrypting them, so they don't count against the sender's limit.
+func (t *_RateLimiter) RefundSender(sender string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if bucket, ok := t.senders[sender]; ok {
+		bucket.tokens = min(bucket.tokens+1, bucket.burst)
+	}
+}
+
+// Takes a token from the bucket of the given sender and key and from the global bucket.
+// Returns ErrKeyRateLimit or ErrGlobalRateLimit in case one of the limits was exceeded.
+func (t *_RateLimiter) AllowKey(sender string, key Key) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	bucket := t.bucket(t.keys, fmt.Sprintf("%s/%s", sender, key), t.config.KeyRate, t.config.KeyBurst, now)
+	if !bucket.allow(now) {
+		return ErrKeyRateLimit
+	}
+	if !t.global.allow(now) {
+		return ErrGlobalRateLimit
+	}
+	return nil
+}
+
+// Returns the bucket with the given ID from the given buckets, creating it if needed.
+// If the maximum number of buckets is reached, the buckets, which got refilled completely,
+// are discarded (at most once per second). If there is still no room, the overflow bucket
+// is returned.
+func (t *_RateLimiter) bucket(buckets map[string]*_TokenBucket, id string, rate float64, burst int, now time.Time) *_TokenBucket {
+	if bucket, ok := buckets[id]; ok {
+		return bucket
+	}
+	if len(buckets) >= RATE_LIMIT_MAX_BUCKETS && now.Sub(t.pruned) >= time.Second {
+		for other, bucket := range buckets {
+			if other != RATE_LIMIT_OVERFLOW_ID && bucket.isFull(now) {
+				delete(buckets, other)
+			}
+		}
+		t.pruned = now
+	}
+	if len(buckets) >= RATE_LIMIT_MAX_BUCKETS {
+		id = RATE_LIMIT_OVERFLOW_ID
+		if bucket, ok := buckets[id]; ok {
+			return bucket
+		}
+	}
+	ret := newTokenBucket(rate, burst, now)
+	buckets[id] = ret
+	return ret
+}
+
+// Discards the buckets, which have not been used for a while.
+func (t *_RateLimiter) cleanup(now time.Time) {
+	if now.Sub(t.cleaned) < RATE_LIMIT_IDLE_TIMEOUT {
+		return
+	}
+	for id, bucket := range t.senders {
+		if now.Sub(bucket.last) > RATE_LIMIT_IDLE_TIMEOUT {
+			delete(t.senders, id)
+		}
+	}
+	for id, bucket := range t.keys {
+		if now.Sub(bucket.last) > RATE_LIMIT_IDLE_TIMEOUT {
+			delete(t.keys, id)
+		}
+	}
+	for sender, until := range t.bans {
+		if now.After(until) {
+			delete(t.bans, sender)
+		}
+	}
+	t.failures = make(map[string]int)
+	t.cleaned = now
+}
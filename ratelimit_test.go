@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRateLimiterCapsSenders(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfiguration{SenderRate: 1, SenderBurst: 1})
+	for i := 0; i < RATE_LIMIT_MAX_BUCKETS; i++ {
+		if err := limiter.AllowSender(fmt.Sprintf("10.0.%d.%d", i/256, i%256)); err != nil {
+			t.Fatalf("sender %d: %v", i, err)
+		}
+	}
+	if err := limiter.AllowSender("192.168.0.1"); err != nil {
+		t.Fatalf("first sender beyond the limit: %v", err)
+	}
+	if err := limiter.AllowSender("192.168.0.2"); !errors.Is(err, ErrSenderRateLimit) {
+		t.Fatalf("second sender beyond the limit: got %v, expected ErrSenderRateLimit", err)
+	}
+	if len(limiter.senders) > RATE_LIMIT_MAX_BUCKETS+1 {
+		t.Fatalf("got %d sender buckets, expected at most %d", len(limiter.senders), RATE_LIMIT_MAX_BUCKETS+1)
+	}
+}
+
+func TestRateLimiterDiscardsFullBuckets(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfiguration{SenderRate: 0, SenderBurst: 1})
+	for i := 0; i < RATE_LIMIT_MAX_BUCKETS+10; i++ {
+		if err := limiter.AllowSender(fmt.Sprintf("10.0.%d.%d", i/256, i%256)); err != nil {
+			t.Fatalf("sender %d: %v", i, err)
+		}
+	}
+	if len(limiter.senders) > RATE_LIMIT_MAX_BUCKETS {
+		t.Fatalf("got %d sender buckets, expected at most %d", len(limiter.senders), RATE_LIMIT_MAX_BUCKETS)
+	}
+}
+
+func TestRateLimiterRefundSender(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfiguration{SenderRate: 0.001, SenderBurst: 1})
+	for i := 0; i < 3; i++ {
+		if err := limiter.AllowSender("10.0.0.1"); err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		limiter.RefundSender("10.0.0.1")
+	}
+	limiter.AllowSender("10.0.0.1")
+	if err := limiter.AllowSender("10.0.0.1"); !errors.Is(err, ErrSenderRateLimit) {
+		t.Fatalf("got %v, expected ErrSenderRateLimit", err)
+	}
+}
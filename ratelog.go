@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Maximum number of keys tracked by a rate-limited logger. Keys are often derived from
+// (spoofable) sender addresses, so messages of further keys share a single entry until
+// older entries expire.
+const RATE_LIMITED_LOG_MAX_ENTRIES = 1024
+
+// Key of the entry shared by the messages exceeding RATE_LIMITED_LOG_MAX_ENTRIES.
+const RATE_LIMITED_LOG_OVERFLOW_KEY = "\x00overflow"
+
+// Logger, which writes at most one message per key and interval.
+// Messages dropped within the interval are counted and reported along with the
+// next message written for the same key.
+type _RateLimitedLog struct {
+	interval time.Duration
+	entries  map[string]*_RateLimitedLogEntry
+	lock     sync.Mutex
+}
+
+type _RateLimitedLogEntry struct {
+	last       time.Time
+	suppressed int
+}
+
+// Create a new rate-limited logger, writing at most one message per key and the given interval.
+func NewRateLimitedLog(interval time.Duration) *_RateLimitedLog {
+	ret := new(_RateLimitedLog)
+	ret.interval = interval
+	ret.entries = make(map[string]*_RateLimitedLogEntry)
+	return ret
+}
+
+// Writes the formatted message to the log, unless another message with the same key was
+// written within the configured interval. Using the log level "debug", every message is
+// written; using "error", none.
+func (t *_RateLimitedLog) Printf(key string, format string, v ...interface{}) {
+	t.write(LOG_LEVEL_INFO, key, format, v...)
+}
+
+// Writes the formatted error message to the log like Printf, also using the log level
+// "error".
+func (t *_RateLimitedLog) PrintError(key string, format string, v ...interface{}) {
+	t.write(LOG_LEVEL_ERROR, key, format, v...)
+}
+
+func (t *_RateLimitedLog) write(level string, key string, format string, v ...interface{}) {
+	if logLevel == LOG_LEVEL_DEBUG {
+		logDebug(format, v...)
+		return
+	}
+	if !logEnabled(level) {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := time.Now()
+	entry, ok := t.entries[key]
+	if !ok {
+		t.cleanup(now)
+		if len(t.entries) >= RATE_LIMITED_LOG_MAX_ENTRIES {
+			entry, ok = t.entries[RATE_LIMITED_LOG_OVERFLOW_KEY]
+			key = RATE_LIMITED_LOG_OVERFLOW_KEY
+		}
+	}
+	if !ok {
+		entry = new(_RateLimitedLogEntry)
+		t.entries[key] = entry
+	} else if now.Sub(entry.last) < t.interval {
+		entry.suppressed++
+		return
+	}
+
+	msg := fmt.Sprintf(format, v...)
+	if entry.suppressed > 0 {
+		msg = fmt.Sprintf("%s (%d similar messages suppressed)", msg, entry.suppressed)
+	}
+	logMessage(level, "%s", msg)
+	entry.last = now
+	entry.suppressed = 0
+}
+
+// Removes the entries, whose interval elapsed. The next message of such a key is written
+// anyway; the number of messages suppressed before is not reported then.
+func (t *_RateLimitedLog) cleanup(now time.Time) {
+	for key, entry := range t.entries {
+		if now.Sub(entry.last) >= t.interval {
+			delete(t.entries, key)
+		}
+	}
+}
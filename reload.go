@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Interval for checking the configuration storage for changes.
+const CONFIG_WATCH_INTERVAL = 2 * time.Second
+
+// Watches the given configuration storage and calls the given function, whenever the
+// configuration changed or SIGHUP was received.
+// A change is picked up once the storage didn't change for one interval, so that a
+// configuration written in several steps is not reloaded halfway.
+// The reload function returns an error, if the new configuration was rejected. The
+// running configuration is kept then.
+// The function blocks until the given context is cancelled.
+func WatchConfiguration(ctx context.Context, store ConfigStore, reload func() error) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	ticker := time.NewTicker(CONFIG_WATCH_INTERVAL)
+	defer ticker.Stop()
+
+	revision := store.Revision()
+	pending := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			logInfo("Reloading configuration (SIGHUP)")
+		case <-ticker.C:
+			current := store.Revision()
+			if current != revision {
+				revision = current
+				pending = true
+				continue
+			}
+			if !pending {
+				continue
+			}
+			logInfo("Configuration in %s changed, reloading", store.Location())
+		}
+		pending = false
+		if err := reload(); err != nil {
+			logError("Rejected new configuration, keeping the current one: %s", err)
+		}
+	}
+}
+
+// Returns a value, which changes whenever the given file changes.
+// Returns an empty string, if the file doesn't exist.
+func fileRevision(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", info.ModTime().UnixNano(), info.Size())
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Maximum number of events of all senders, in multiples of the per-sender queue size.
+const SCHEDULER_TOTAL_FACTOR = 16
+
+// Queue of events from several senders.
+// Events of the same sender are returned in the order they were pushed, while the
+// senders are served round-robin, so a single busy sender can't delay the others.
+// Each sender has its own bounded queue using the configured drop policy. Events older
+// than the configured maximum age are dropped instead of being returned.
+type _FairScheduler struct {
+	name         string
+	policy       string
+	blockTimeout time.Duration
+	size         int
+	maxAge       time.Duration
+	counters     *_Counters
+
+	lock    sync.Mutex
+	queues  map[string][]*_ScheduledEvent
+	order   []string
+	total   int
+	closed  bool
+	ready   chan struct{}
+	removed chan struct{}
+}
+
+type _ScheduledEvent struct {
+	event    interface{}
+	received time.Time
+}
+
+// Create a new scheduler using the given queue configuration for each sender.
+// Events older than maxAge are dropped, a maxAge of zero keeps all events.
+// The counters '<name>_enqueued', '<name>_dropped_oldest', '<name>_dropped_newest',
+// '<name>_dropped_timeout', '<name>_dropped_overflow' and '<name>_dropped_stale' are
+// maintained inside the given counters object.
+func NewFairScheduler(name string, config QueueConfiguration, maxAge time.Duration, counters *_Counters) *_FairScheduler {
+	ret := new(_FairScheduler)
+	ret.name = name
+	ret.policy = config.DropPolicy
+	ret.blockTimeout = time.Duration(config.BlockTimeoutMilliseconds) * time.Millisecond
+	ret.size = config.Size
+	if ret.size < 1 {
+		ret.size = 1
+	}
+	ret.maxAge = maxAge
+	ret.counters = counters
+	ret.queues = make(map[string][]*_ScheduledEvent)
+	ret.ready = make(chan struct{}, 1)
+	ret.removed = make(chan struct{})
+	return ret
+}
+
+// Adds the given event of the given sender.
+// Returns false, if the event had to be dropped.
+func (t *_FairScheduler) Push(sender string, event interface{}) bool {
+	scheduled := &_ScheduledEvent{event, time.Now()}
+	var deadline <-chan time.Time
+
+	t.lock.Lock()
+	for len(t.queues[sender]) >= t.size {
+		switch t.policy {
+		case DROP_NEWEST:
+			t.lock.Unlock()
+			t.counters.Inc(t.name + "_dropped_newest")
+			return false
+		case DROP_BLOCK:
+			if deadline == nil {
+				timer := time.NewTimer(t.blockTimeout)
+				defer timer.Stop()
+				deadline = timer.C
+			}
+			removed := t.removed
+			t.lock.Unlock()
+			select {
+			case <-removed:
+			case <-deadline:
+				t.counters.Inc(t.name + "_dropped_timeout")
+				return false
+			}
+			t.lock.Lock()
+		default:
+			t.queues[sender] = t.queues[sender][1:]
+			t.total--
+			t.counters.Inc(t.name + "_dropped_oldest")
+			if len(t.queues[sender]) == 0 {
+				t.removeSender(sender)
+			}
+		}
+	}
+	if t.closed || t.total >= t.size*SCHEDULER_TOTAL_FACTOR {
+		t.lock.Unlock()
+		t.counters.Inc(t.name + "_dropped_overflow")
+		return false
+	}
+	if len(t.queues[sender]) == 0 {
+		t.order = append(t.order, sender)
+	}
+	t.queues[sender] = append(t.queues[sender], scheduled)
+	t.total++
+	t.lock.Unlock()
+
+	t.counters.Inc(t.name + "_enqueued")
+	select {
+	case t.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Returns the next event, taking turns between the senders.
+// Blocks until an event is available. Returns false, if the scheduler was closed and
+// all remaining events were returned.
+func (t *_FairScheduler) Pop() (interface{}, bool) {
+	for {
+		t.lock.Lock()
+		for len(t.order) > 0 {
+			sender := t.order[0]
+			t.order = t.order[1:]
+			queue := t.queues[sender]
+			if len(queue) == 0 {
+				delete(t.queues, sender)
+				continue
+			}
+			scheduled := queue[0]
+			if len(queue) > 1 {
+				t.queues[sender] = queue[1:]
+				t.order = append(t.order, sender)
+			} else {
+				delete(t.queues, sender)
+			}
+			t.total--
+			close(t.removed)
+			t.removed = make(chan struct{})
+
+			if t.maxAge > 0 && time.Since(scheduled.received) > t.maxAge {
+				t.counters.Inc(t.name + "_dropped_stale")
+				continue
+			}
+			t.lock.Unlock()
+			return scheduled.event, true
+		}
+		closed := t.closed
+		t.lock.Unlock()
+		if closed {
+			return nil, false
+		}
+		<-t.ready
+	}
+}
+
+// Removes the given sender, whose queue became empty, from the turns.
+func (t *_FairScheduler) removeSender(sender string) {
+	delete(t.queues, sender)
+	for i, queued := range t.order {
+		if queued == sender {
+			t.order = append(t.order[:i], t.order[i+1:]...)
+			return
+		}
+	}
+}
+
+// Closes the scheduler. Events pushed afterwards are dropped.
+// Pop() returns the remaining events, before reporting the end of the queue.
+func (t *_FairScheduler) Close() {
+	t.lock.Lock()
+	t.closed = true
+	t.lock.Unlock()
+	select {
+	case t.ready <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+)
+
+// Returns the events of the given scheduler after closing it.
+func popAll(scheduler *_FairScheduler) []interface{} {
+	scheduler.Close()
+	ret := make([]interface{}, 0)
+	for {
+		event, ok := scheduler.Pop()
+		if !ok {
+			return ret
+		}
+		ret = append(ret, event)
+	}
+}
+
+func TestFairSchedulerDropOldestSingleSlot(t *testing.T) {
+	scheduler := NewFairScheduler("test", QueueConfiguration{Size: 1, DropPolicy: DROP_OLDEST}, 0, NewCounters())
+	scheduler.Push("a", 1)
+	scheduler.Push("a", 2)
+	scheduler.Push("b", 3)
+	scheduler.Push("a", 4)
+	events := popAll(scheduler)
+	if len(events) != 2 || events[0] != 3 || events[1] != 4 {
+		t.Fatalf("got events %v, expected [3 4]", events)
+	}
+}
+
+func TestFairSchedulerTakesTurns(t *testing.T) {
+	scheduler := NewFairScheduler("test", QueueConfiguration{Size: 4, DropPolicy: DROP_OLDEST}, 0, NewCounters())
+	for _, event := range []int{1, 2, 3} {
+		scheduler.Push("a", event)
+	}
+	scheduler.Push("b", 10)
+	events := popAll(scheduler)
+	expected := []interface{}{1, 10, 2, 3}
+	if len(events) != len(expected) {
+		t.Fatalf("got events %v, expected %v", events, expected)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Fatalf("got events %v, expected %v", events, expected)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Default time, old key generations are still accepted after a rotation.
+const DEFAULT_ROTATION_GRACE = 24 * time.Hour
+
+// Manages the key generations of the server configuration.
+// Supported actions: rotate [-grace <duration>], list
+func ManageSecret(args []string) {
+	if len(args) < 1 {
+		log.Fatal("Missing argument")
+	}
+	switch args[0] {
+	case "rotate":
+		flags := flag.NewFlagSet("secret rotate", flag.ExitOnError)
+		grace := flags.Duration("grace", DEFAULT_ROTATION_GRACE, "time the old secrets are still accepted")
+		flags.Parse(args[1:])
+		RotateSecret(*grace)
+	case "list":
+		configuration := loadServerConfigurationForUpdate()
+		if len(configuration.Secret) > 0 {
+			fmt.Printf("%-20s %-25s %s\n", "(default)", "-", formatExpiry(configuration.SecretNotAfter))
+		}
+		for _, generation := range configuration.KeyGenerations {
+			fmt.Printf("%-20s %-25s %s\n", generation.ID, generation.NotBefore.Format(time.RFC3339),
+				formatExpiry(generation.NotAfter))
+		}
+	default:
+		log.Fatal("Unknown secret action")
+	}
+}
+
+// Creates a new key generation with a random secret.
+// The default secret and all other key generations expire after the given grace period.
+// Generations, which are already expired, are removed.
+func RotateSecret(grace time.Duration) {
+	now := time.Now()
+	configuration := loadServerConfigurationForUpdate()
+
+	expiry := now.Add(grace)
+	if len(configuration.Secret) > 0 && (configuration.SecretNotAfter.IsZero() || configuration.SecretNotAfter.After(expiry)) {
+		configuration.SecretNotAfter = expiry
+	}
+	generations := make([]KeyGeneration, 0, len(configuration.KeyGenerations)+1)
+	for _, generation := range configuration.KeyGenerations {
+		if !generation.NotAfter.IsZero() && !now.Before(generation.NotAfter) {
+			if err := DeleteServerKeySecret(configuration, generation.ID); err != nil {
+				logError("Unable to remove the secret of key generation '%s': %s", generation.ID, err)
+			}
+			continue
+		}
+		if generation.NotAfter.IsZero() || generation.NotAfter.After(expiry) {
+			generation.NotAfter = expiry
+		}
+		generations = append(generations, generation)
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatal(err)
+	}
+	generation := KeyGeneration{
+		ID:        newKeyGenerationID(configuration, now),
+		Secret:    []byte(base64.RawURLEncoding.EncodeToString(secret)),
+		NotBefore: now,
+	}
+	configuration.KeyGenerations = append(generations, generation)
+	if err := StoreServerConfiguration(configuration); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("%-10s: %s\n", "Key ID", generation.ID)
+	fmt.Printf("%-10s: %s\n", "Password", generation.Secret)
+	fmt.Printf("Previous secrets expire at %s\n", expiry.Format(time.RFC3339))
+}
+
+// Returns an unused key ID for a generation created at the given time.
+func newKeyGenerationID(configuration *ServerConfiguration, now time.Time) string {
+	base := "key-" + now.Format("20060102-150405")
+	id := base
+	for i := 2; configuration.FindClient(id) != nil || findKeyGeneration(configuration, id) != nil; i++ {
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+	return id
+}
+
+func findKeyGeneration(configuration *ServerConfiguration, id string) *KeyGeneration {
+	for i := range configuration.KeyGenerations {
+		if configuration.KeyGenerations[i].ID == id {
+			return &configuration.KeyGenerations[i]
+		}
+	}
+	return nil
+}
+
+func formatExpiry(notAfter time.Time) string {
+	if notAfter.IsZero() {
+		return "never expires"
+	}
+	return "expires " + notAfter.Format(time.RFC3339)
+}
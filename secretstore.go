@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Names of the secret storage backends, which may be used as the SecretStore setting.
+const (
+	SECRET_STORE_CREDENTIAL_MANAGER = "wincred"
+	SECRET_STORE_SECRET_SERVICE     = "secret-service"
+	SECRET_STORE_FILE               = "file"
+)
+
+// Returned by SecretStore.Load, if there is no secret with the given name.
+var ErrSecretNotFound = errors.New("secret not found")
+
+// Storage of the secrets of the client and server configuration.
+// Secrets are identified by name (see credentials.go).
+type SecretStore interface {
+	// Returns the secret with the given name or ErrSecretNotFound.
+	Load(name string) ([]byte, error)
+	// Saves the secret with the given name, replacing an existing one.
+	Store(name string, secret []byte) error
+	// Removes the secret with the given name. Removing a missing secret is no error.
+	Delete(name string) error
+}
+
+// Returns the secret storage with the given name.
+// An empty name selects the platform's default storage (see defaultSecretStore).
+func OpenSecretStore(name string) (SecretStore, error) {
+	if len(name) == 0 {
+		name = defaultSecretStore()
+	}
+	switch name {
+	case SECRET_STORE_CREDENTIAL_MANAGER:
+		return newCredentialManagerStore()
+	case SECRET_STORE_SECRET_SERVICE:
+		return NewSecretServiceStore(), nil
+	case SECRET_STORE_FILE:
+		return NewSecretFileStore(DefaultSecretFilePath()), nil
+	default:
+		return nil, fmt.Errorf("unknown secret store '%s'", name)
+	}
+}
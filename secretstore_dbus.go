@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+)
+
+// Names of the freedesktop Secret Service D-Bus API:
+// https://specifications.freedesktop.org/secret-service/
+const (
+	SECRET_SERVICE_NAME               = "org.freedesktop.secrets"
+	SECRET_SERVICE_PATH               = "/org/freedesktop/secrets"
+	SECRET_SERVICE_DEFAULT_COLLECTION = "/org/freedesktop/secrets/aliases/default"
+	SECRET_SERVICE_INTERFACE          = "org.freedesktop.Secret.Service"
+	SECRET_COLLECTION_INTERFACE       = "org.freedesktop.Secret.Collection"
+	SECRET_ITEM_INTERFACE             = "org.freedesktop.Secret.Item"
+	SECRET_PROMPT_INTERFACE           = "org.freedesktop.Secret.Prompt"
+
+	// Attribute identifying the items of this program.
+	SECRET_SERVICE_ATTRIBUTE = "keyfwd"
+)
+
+// Secret transferred over D-Bus (the Secret struct of the Secret Service API).
+type _DBusSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// Secret storage using the freedesktop Secret Service (e.g. GNOME Keyring, KWallet) on
+// the D-Bus session bus.
+// Items are stored in the default collection. Locked items are unlocked, which may show a
+// prompt of the desktop session.
+type _SecretServiceStore struct{}
+
+// Returns a secret storage using the Secret Service on the D-Bus session bus.
+// The bus given by DBUS_SESSION_BUS_ADDRESS is used, so a local dbus-daemon may stand in
+// for the desktop session.
+func NewSecretServiceStore() SecretStore {
+	return new(_SecretServiceStore)
+}
+
+func (t *_SecretServiceStore) Load(name string) ([]byte, error) {
+	conn, session, err := t.openSession()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	items, err := t.search(conn, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, ErrSecretNotFound
+	}
+	var secret _DBusSecret
+	item := conn.Object(SECRET_SERVICE_NAME, items[0])
+	if err := item.Call(SECRET_ITEM_INTERFACE+".GetSecret", 0, session).Store(&secret); err != nil {
+		return nil, fmt.Errorf("unable to read secret '%s': %w", name, err)
+	}
+	return secret.Value, nil
+}
+
+func (t *_SecretServiceStore) Store(name string, secret []byte) error {
+	conn, session, err := t.openSession()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	collection := conn.Object(SECRET_SERVICE_NAME, SECRET_SERVICE_DEFAULT_COLLECTION)
+	if err := t.unlock(conn, []dbus.ObjectPath{SECRET_SERVICE_DEFAULT_COLLECTION}); err != nil {
+		return err
+	}
+	properties := map[string]dbus.Variant{
+		SECRET_ITEM_INTERFACE + ".Label":      dbus.MakeVariant(name),
+		SECRET_ITEM_INTERFACE + ".Attributes": dbus.MakeVariant(secretServiceAttributes(name)),
+	}
+	value := _DBusSecret{Session: session, Value: secret, ContentType: "text/plain"}
+	var item, prompt dbus.ObjectPath
+	if err := collection.Call(SECRET_COLLECTION_INTERFACE+".CreateItem", 0, properties, value, true).Store(&item, &prompt); err != nil {
+		return fmt.Errorf("unable to store secret '%s': %w", name, err)
+	}
+	return t.prompt(conn, prompt)
+}
+
+func (t *_SecretServiceStore) Delete(name string) error {
+	conn, _, err := t.openSession()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	items, err := t.search(conn, name)
+	if err != nil {
+		return err
+	}
+	for _, path := range items {
+		var prompt dbus.ObjectPath
+		if err := conn.Object(SECRET_SERVICE_NAME, path).Call(SECRET_ITEM_INTERFACE+".Delete", 0).Store(&prompt); err != nil {
+			return fmt.Errorf("unable to remove secret '%s': %w", name, err)
+		}
+		if err := t.prompt(conn, prompt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Connects to the session bus and opens a session transferring the secrets unencrypted.
+// The session bus is local to the user, so the secrets don't leave the machine.
+func (t *_SecretServiceStore) openSession() (*dbus.Conn, dbus.ObjectPath, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to connect to the D-Bus session bus: %w", err)
+	}
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	service := conn.Object(SECRET_SERVICE_NAME, SECRET_SERVICE_PATH)
+	if err := service.Call(SECRET_SERVICE_INTERFACE+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session); err != nil {
+		conn.Close()
+		return nil, "", fmt.Errorf("unable to open a Secret Service session: %w", err)
+	}
+	return conn, session, nil
+}
+
+// Returns the items of the secret with the given name. Locked items get unlocked.
+func (t *_SecretServiceStore) search(conn *dbus.Conn, name string) ([]dbus.ObjectPath, error) {
+	var unlocked, locked []dbus.ObjectPath
+	service := conn.Object(SECRET_SERVICE_NAME, SECRET_SERVICE_PATH)
+	if err := service.Call(SECRET_SERVICE_INTERFACE+".SearchItems", 0, secretServiceAttributes(name)).Store(&unlocked, &locked); err != nil {
+		return nil, fmt.Errorf("unable to search secret '%s': %w", name, err)
+	}
+	if len(locked) > 0 {
+		if err := t.unlock(conn, locked); err != nil {
+			return nil, err
+		}
+	}
+	return append(unlocked, locked...), nil
+}
+
+// Unlocks the given items or collections.
+func (t *_SecretServiceStore) unlock(conn *dbus.Conn, objects []dbus.ObjectPath) error {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	service := conn.Object(SECRET_SERVICE_NAME, SECRET_SERVICE_PATH)
+	if err := service.Call(SECRET_SERVICE_INTERFACE+".Unlock", 0, objects).Store(&unlocked, &prompt); err != nil {
+		return fmt.Errorf("unable to unlock the secret storage: %w", err)
+	}
+	return t.prompt(conn, prompt)
+}
+
+// Shows the given prompt and waits for the user to complete it.
+// The path "/" means, that no prompt is necessary.
+func (t *_SecretServiceStore) prompt(conn *dbus.Conn, prompt dbus.ObjectPath) error {
+	if prompt == "/" || len(prompt) == 0 {
+		return nil
+	}
+	if err := conn.AddMatchSignal(dbus.WithMatchObjectPath(prompt), dbus.WithMatchInterface(SECRET_PROMPT_INTERFACE)); err != nil {
+		return err
+	}
+	signals := make(chan *dbus.Signal, 1)
+	conn.Signal(signals)
+	defer conn.RemoveSignal(signals)
+	if err := conn.Object(SECRET_SERVICE_NAME, prompt).Call(SECRET_PROMPT_INTERFACE+".Prompt", 0, "").Err; err != nil {
+		return err
+	}
+	for signal := range signals {
+		if signal.Path != prompt || signal.Name != SECRET_PROMPT_INTERFACE+".Completed" {
+			continue
+		}
+		if len(signal.Body) == 0 {
+			return nil
+		}
+		if dismissed, ok := signal.Body[0].(bool); ok && dismissed {
+			return errors.New("the Secret Service prompt was dismissed")
+		}
+		return nil
+	}
+	return errors.New("connection to the Secret Service closed")
+}
+
+// Returns the attributes identifying the secret with the given name.
+func secretServiceAttributes(name string) map[string]string {
+	return map[string]string{"application": SECRET_SERVICE_ATTRIBUTE, "name": name}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/godbus/dbus/v5"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Minimal Secret Service, storing the items of the default collection in memory.
+type _MockSecretService struct {
+	conn  *dbus.Conn
+	lock  sync.Mutex
+	items map[dbus.ObjectPath]*_MockSecretItem
+	next  int
+}
+
+type _MockSecretItem struct {
+	service    *_MockSecretService
+	path       dbus.ObjectPath
+	attributes map[string]string
+	secret     []byte
+}
+
+func (t *_MockSecretService) OpenSession(algorithm string, input dbus.Variant) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+	if algorithm != "plain" {
+		return dbus.MakeVariant(""), "/", dbus.MakeFailedError(fmt.Errorf("unsupported algorithm '%s'", algorithm))
+	}
+	return dbus.MakeVariant(""), "/org/freedesktop/secrets/session/1", nil
+}
+
+func (t *_MockSecretService) SearchItems(attributes map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, *dbus.Error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	ret := make([]dbus.ObjectPath, 0)
+	for path, item := range t.items {
+		if item.matches(attributes) {
+			ret = append(ret, path)
+		}
+	}
+	return ret, []dbus.ObjectPath{}, nil
+}
+
+func (t *_MockSecretService) Unlock(objects []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	return objects, "/", nil
+}
+
+// Collection interface of the default collection.
+type _MockSecretCollection struct {
+	service *_MockSecretService
+}
+
+func (t *_MockSecretCollection) CreateItem(properties map[string]dbus.Variant, secret _DBusSecret, replace bool) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	service := t.service
+	service.lock.Lock()
+	defer service.lock.Unlock()
+	attributes, ok := properties[SECRET_ITEM_INTERFACE+".Attributes"].Value().(map[string]string)
+	if !ok {
+		return "/", "/", dbus.MakeFailedError(errors.New("missing attributes"))
+	}
+	if replace {
+		for _, item := range service.items {
+			if item.matches(attributes) {
+				item.secret = secret.Value
+				return item.path, "/", nil
+			}
+		}
+	}
+	service.next++
+	item := &_MockSecretItem{service, dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/secrets/collection/login/%d", service.next)), attributes, secret.Value}
+	if err := service.conn.Export(item, item.path, SECRET_ITEM_INTERFACE); err != nil {
+		return "/", "/", dbus.MakeFailedError(err)
+	}
+	service.items[item.path] = item
+	return item.path, "/", nil
+}
+
+func (t *_MockSecretItem) GetSecret(session dbus.ObjectPath) (_DBusSecret, *dbus.Error) {
+	t.service.lock.Lock()
+	defer t.service.lock.Unlock()
+	return _DBusSecret{Session: session, Parameters: []byte{}, Value: t.secret, ContentType: "text/plain"}, nil
+}
+
+func (t *_MockSecretItem) Delete() (dbus.ObjectPath, *dbus.Error) {
+	t.service.lock.Lock()
+	defer t.service.lock.Unlock()
+	delete(t.service.items, t.path)
+	t.service.conn.Export(nil, t.path, SECRET_ITEM_INTERFACE)
+	return "/", nil
+}
+
+// Returns true, if the item has all of the given attributes.
+func (t *_MockSecretItem) matches(attributes map[string]string) bool {
+	for key, value := range attributes {
+		if t.attributes[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Starts a private session bus running the mock Secret Service and selects it using
+// DBUS_SESSION_BUS_ADDRESS. Skips the test, if dbus-daemon is not available.
+func startMockSecretService(t *testing.T) {
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not available")
+	}
+	address := "unix:path=" + filepath.Join(t.TempDir(), "bus")
+	cmd := exec.Command(daemon, "--session", "--nofork", "--nopidfile", "--address="+address)
+	if err := cmd.Start(); err != nil {
+		t.Skipf("unable to start dbus-daemon: %s", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	var conn *dbus.Conn
+	for start := time.Now(); ; time.Sleep(50 * time.Millisecond) {
+		if conn, err = dbus.Connect(address); err == nil {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Skipf("unable to connect to dbus-daemon: %s", err)
+		}
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	service := &_MockSecretService{conn: conn, items: make(map[dbus.ObjectPath]*_MockSecretItem)}
+	if err := conn.Export(service, SECRET_SERVICE_PATH, SECRET_SERVICE_INTERFACE); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Export(&_MockSecretCollection{service}, SECRET_SERVICE_DEFAULT_COLLECTION, SECRET_COLLECTION_INTERFACE); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := conn.RequestName(SECRET_SERVICE_NAME, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("unable to own %s: %v", SECRET_SERVICE_NAME, err)
+	}
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", address)
+}
+
+func TestSecretServiceStoreRoundTrip(t *testing.T) {
+	startMockSecretService(t)
+	store := NewSecretServiceStore()
+
+	if _, err := store.Load("danieljoos/keyfwd/client"); !errors.Is(err, ErrSecretNotFound) {
+		t.Fatalf("loading a missing secret: got %v, expected ErrSecretNotFound", err)
+	}
+	if err := store.Store("danieljoos/keyfwd/client", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Store("danieljoos/keyfwd/server", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Store("danieljoos/keyfwd/client", []byte("replaced")); err != nil {
+		t.Fatal(err)
+	}
+	if secret, err := store.Load("danieljoos/keyfwd/client"); err != nil || !bytes.Equal(secret, []byte("replaced")) {
+		t.Fatalf("got secret '%s' (%v), expected 'replaced'", secret, err)
+	}
+	if err := store.Delete("danieljoos/keyfwd/client"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load("danieljoos/keyfwd/client"); !errors.Is(err, ErrSecretNotFound) {
+		t.Fatalf("loading a removed secret: got %v, expected ErrSecretNotFound", err)
+	}
+	if err := store.Delete("danieljoos/keyfwd/client"); err != nil {
+		t.Fatalf("removing a missing secret: %v", err)
+	}
+	if secret, err := store.Load("danieljoos/keyfwd/server"); err != nil || !bytes.Equal(secret, []byte("second")) {
+		t.Fatalf("got secret '%s' (%v), expected 'second'", secret, err)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Environment variables of the encrypted secret file.
+const (
+	ENV_SECRETS_FILE      = "KEYFWD_SECRETS_FILE"
+	ENV_SECRET_PASSPHRASE = "KEYFWD_SECRET_PASSPHRASE"
+)
+
+// Number of PBKDF2 iterations deriving the file key from the passphrase.
+const SECRET_FILE_ITERATIONS = 600000
+
+// Accepted key derivation parameters of files read, e.g. secret files and bundles. Fewer
+// iterations would weaken the key, more would take ages to derive it.
+const (
+	SECRET_FILE_SALT_SIZE      = 16
+	SECRET_FILE_MIN_ITERATIONS = 100000
+	SECRET_FILE_MAX_ITERATIONS = 10000000
+)
+
+// Returned, if the passphrase doesn't match the secret file.
+var ErrWrongPassphrase = errors.New("wrong passphrase for the secret file")
+
+// Passphrase of the secret file, asked for at most once per process, along with the
+// ciphers derived from it by file, salt and iterations. Deriving a key takes a while, so
+// loading many secrets derives it only once.
+var secretFilePassphrase struct {
+	value   []byte
+	ciphers map[string]cipher.AEAD
+	lock    sync.Mutex
+}
+
+// Contents of the secret file.
+// Each secret is encrypted separately using AES-GCM with a key derived from the passphrase.
+// The name of the secret is used as additional data, so secrets can't be swapped.
+type _SecretFile struct {
+	Salt       []byte
+	Iterations int
+	Secrets    map[string][]byte
+}
+
+// Secret storage in a passphrase-encrypted file, intended for machines without a
+// desktop session (and thus without Secret Service).
+// The passphrase is taken from the KEYFWD_SECRET_PASSPHRASE environment variable or asked
+// for on the terminal.
+type _SecretFileStore struct {
+	path string
+}
+
+// Returns the path of the secret file, if no other file was selected using the
+// KEYFWD_SECRETS_FILE environment variable: "secrets.json" next to the configuration
+// file in use (see ConfigFilePath).
+func DefaultSecretFilePath() string {
+	if path := os.Getenv(ENV_SECRETS_FILE); len(path) > 0 {
+		return path
+	}
+	path, err := ConfigFilePath()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(path), "secrets.json")
+}
+
+// Returns a secret storage using the encrypted file at the given path.
+func NewSecretFileStore(path string) SecretStore {
+	ret := new(_SecretFileStore)
+	ret.path = path
+	return ret
+}
+
+func (t *_SecretFileStore) Load(name string) ([]byte, error) {
+	file, err := t.read()
+	if err != nil {
+		return nil, err
+	}
+	sealed, ok := file.Secrets[name]
+	if !ok {
+		return nil, ErrSecretNotFound
+	}
+	aead, err := t.cipher(file)
+	if err != nil {
+		return nil, err
+	}
+	return openSecret(aead, name, sealed)
+}
+
+func (t *_SecretFileStore) Store(name string, secret []byte) error {
+	file, err := t.read()
+	if err != nil {
+		return err
+	}
+	aead, err := t.cipher(file)
+	if err != nil {
+		return err
+	}
+	// Make sure, the passphrase matches the one of the other secrets.
+	for other, sealed := range file.Secrets {
+		if _, err := openSecret(aead, other, sealed); err != nil {
+			return err
+		}
+		break
+	}
+	if file.Secrets[name], err = sealSecret(aead, name, secret); err != nil {
+		return err
+	}
+	return t.write(file)
+}
+
+func (t *_SecretFileStore) Delete(name string) error {
+	file, err := t.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := file.Secrets[name]; !ok {
+		return nil
+	}
+	delete(file.Secrets, name)
+	return t.write(file)
+}
+
+// Reads the secret file. A missing file is treated as a file without secrets.
+func (t *_SecretFileStore) read() (*_SecretFile, error) {
+	if len(t.path) == 0 {
+		return nil, fmt.Errorf("no secret file (set %s)", ENV_SECRETS_FILE)
+	}
+	ret := new(_SecretFile)
+	data, err := os.ReadFile(t.path)
+	if os.IsNotExist(err) {
+		ret.Salt = make([]byte, SECRET_FILE_SALT_SIZE)
+		if _, err := rand.Read(ret.Salt); err != nil {
+			return nil, err
+		}
+		ret.Iterations = SECRET_FILE_ITERATIONS
+	} else if err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(data, ret); err != nil {
+		return nil, fmt.Errorf("unable to read secret file '%s': %s", t.path, err)
+	} else if err := checkKeyDerivation(ret.Salt, ret.Iterations); err != nil {
+		return nil, fmt.Errorf("unable to read secret file '%s': %s", t.path, err)
+	}
+	if ret.Secrets == nil {
+		ret.Secrets = make(map[string][]byte)
+	}
+	return ret, nil
+}
+
+// Writes the secret file, readable by the current user only.
+func (t *_SecretFileStore) write(file *_SecretFile) error {
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0700); err != nil {
+		return err
+	}
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.path)
+}
+
+// Returns the AES-GCM cipher using the key derived from the passphrase.
+// The cipher is derived once per file, salt and iterations.
+func (t *_SecretFileStore) cipher(file *_SecretFile) (cipher.AEAD, error) {
+	passphrase, err := getSecretFilePassphrase(t.path)
+	if err != nil {
+		return nil, err
+	}
+	secretFilePassphrase.lock.Lock()
+	defer secretFilePassphrase.lock.Unlock()
+	id := fmt.Sprintf("%s\x00%x\x00%d", t.path, file.Salt, file.Iterations)
+	if ret, ok := secretFilePassphrase.ciphers[id]; ok {
+		return ret, nil
+	}
+	ret, err := newPassphraseCipher(passphrase, file.Salt, file.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if secretFilePassphrase.ciphers == nil {
+		secretFilePassphrase.ciphers = make(map[string]cipher.AEAD)
+	}
+	secretFilePassphrase.ciphers[id] = ret
+	return ret, nil
+}
+
+// Checks the key derivation parameters read from a file.
+func checkKeyDerivation(salt []byte, iterations int) error {
+	if len(salt) != SECRET_FILE_SALT_SIZE {
+		return fmt.Errorf("salt of %d bytes, expected %d bytes", len(salt), SECRET_FILE_SALT_SIZE)
+	}
+	if iterations < SECRET_FILE_MIN_ITERATIONS || iterations > SECRET_FILE_MAX_ITERATIONS {
+		return fmt.Errorf("%d iterations, expected %d to %d", iterations, SECRET_FILE_MIN_ITERATIONS, SECRET_FILE_MAX_ITERATIONS)
+	}
+	return nil
+}
+
+// Returns an AES-GCM cipher using a key derived from the given passphrase (PBKDF2-SHA256).
+func newPassphraseCipher(passphrase []byte, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, string(passphrase), salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypts the given secret. The result starts with a random nonce.
+func sealSecret(aead cipher.AEAD, name string, secret []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, secret, []byte(name)), nil
+}
+
+// Decrypts the given secret, which starts with the nonce.
+func openSecret(aead cipher.AEAD, name string, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrWrongPassphrase
+	}
+	ret, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(name))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return ret, nil
+}
+
+// Returns the passphrase of the secret file.
+// Uses the KEYFWD_SECRET_PASSPHRASE environment variable or asks for it once on stderr
+// (see getPassphrase), so the prompt doesn't end up in exported data.
+func getSecretFilePassphrase(path string) ([]byte, error) {
+	secretFilePassphrase.lock.Lock()
+	defer secretFilePassphrase.lock.Unlock()
+	if secretFilePassphrase.value == nil {
+		value, err := getPassphrase(ENV_SECRET_PASSPHRASE, fmt.Sprintf("secret file %s", path), false)
+		if err != nil {
+			return nil, err
+		}
+		secretFilePassphrase.value = value
+	}
+	return secretFilePassphrase.value, nil
+}
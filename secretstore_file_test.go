@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Selects the given passphrase of the secret file, discarding the one used before along
+// with the ciphers derived from it.
+func setSecretFilePassphrase(t *testing.T, passphrase string) {
+	t.Setenv(ENV_SECRET_PASSPHRASE, passphrase)
+	secretFilePassphrase.lock.Lock()
+	secretFilePassphrase.value = nil
+	secretFilePassphrase.ciphers = nil
+	secretFilePassphrase.lock.Unlock()
+	t.Cleanup(func() {
+		secretFilePassphrase.lock.Lock()
+		secretFilePassphrase.value = nil
+		secretFilePassphrase.ciphers = nil
+		secretFilePassphrase.lock.Unlock()
+	})
+}
+
+func TestSecretFileStoreRoundTrip(t *testing.T) {
+	setSecretFilePassphrase(t, "passphrase")
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	store := NewSecretFileStore(path)
+
+	if _, err := store.Load("client"); !errors.Is(err, ErrSecretNotFound) {
+		t.Fatalf("loading a missing secret: got %v, expected ErrSecretNotFound", err)
+	}
+	if err := store.Store("client", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Store("server", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Store("client", []byte("replaced")); err != nil {
+		t.Fatal(err)
+	}
+	if secret, err := store.Load("client"); err != nil || !bytes.Equal(secret, []byte("replaced")) {
+		t.Fatalf("got secret '%s' (%v), expected 'replaced'", secret, err)
+	}
+	if err := store.Delete("client"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("client"); err != nil {
+		t.Fatalf("removing a missing secret: %v", err)
+	}
+	if _, err := store.Load("client"); !errors.Is(err, ErrSecretNotFound) {
+		t.Fatalf("loading a removed secret: got %v, expected ErrSecretNotFound", err)
+	}
+	if secret, err := store.Load("server"); err != nil || !bytes.Equal(secret, []byte("second")) {
+		t.Fatalf("got secret '%s' (%v), expected 'second'", secret, err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("got file mode %v, expected 0600", info.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("second")) {
+		t.Error("the secret file contains the plain secret")
+	}
+}
+
+func TestSecretFileStoreWrongPassphrase(t *testing.T) {
+	setSecretFilePassphrase(t, "passphrase")
+	store := NewSecretFileStore(filepath.Join(t.TempDir(), "secrets.json"))
+	if err := store.Store("client", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	setSecretFilePassphrase(t, "wrong")
+	if _, err := store.Load("client"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("loading: got %v, expected ErrWrongPassphrase", err)
+	}
+	if err := store.Store("server", []byte("secret")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("storing: got %v, expected ErrWrongPassphrase", err)
+	}
+}
+
+func TestSecretFileStoreKeyDerivationBounds(t *testing.T) {
+	setSecretFilePassphrase(t, "passphrase")
+	for _, content := range []string{
+		`{"Salt": "AAAAAAAAAAAAAAAAAAAAAA==", "Iterations": 2000000000, "Secrets": {}}`,
+		`{"Salt": "AAAAAAAAAAAAAAAAAAAAAA==", "Iterations": 0, "Secrets": {}}`,
+		`{"Salt": "AAAA", "Iterations": 600000, "Secrets": {}}`,
+	} {
+		path := filepath.Join(t.TempDir(), "secrets.json")
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewSecretFileStore(path).Load("client"); err == nil || errors.Is(err, ErrSecretNotFound) {
+			t.Errorf("%s: got %v, expected an invalid file", content, err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// Secret storage used, if none was configured and a session bus is available.
+const DEFAULT_SECRET_STORE = SECRET_STORE_SECRET_SERVICE
+
+// Returns the secret storage used, if none was configured: the Secret Service, if a
+// session bus is available, the encrypted file otherwise. This way, the configuration
+// file can be used on machines without a desktop session.
+func defaultSecretStore() string {
+	if len(os.Getenv("DBUS_SESSION_BUS_ADDRESS")) > 0 {
+		return DEFAULT_SECRET_STORE
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); len(dir) > 0 {
+		if _, err := os.Stat(filepath.Join(dir, "bus")); err == nil {
+			return DEFAULT_SECRET_STORE
+		}
+	}
+	return SECRET_STORE_FILE
+}
+
+func newCredentialManagerStore() (SecretStore, error) {
+	return nil, errors.New("the Windows credential manager is not available on this platform")
+}
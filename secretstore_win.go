@@ -0,0 +1,48 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"github.com/danieljoos/wincred"
+)
+
+// Secret storage used, if none was configured.
+const DEFAULT_SECRET_STORE = SECRET_STORE_CREDENTIAL_MANAGER
+
+// Returns the secret storage used, if none was configured.
+func defaultSecretStore() string {
+	return DEFAULT_SECRET_STORE
+}
+
+// Secret storage using the Windows credential manager.
+type _CredentialManagerStore struct{}
+
+func newCredentialManagerStore() (SecretStore, error) {
+	return new(_CredentialManagerStore), nil
+}
+
+// Returns the secret with the given name from the Windows credential store.
+func (t *_CredentialManagerStore) Load(name string) ([]byte, error) {
+	cred, err := wincred.GetGenericCredential(name)
+	if err != nil {
+		return nil, ErrSecretNotFound
+	}
+	return cred.CredentialBlob, nil
+}
+
+// Saves the secret with the given name to the Windows credential store.
+func (t *_CredentialManagerStore) Store(name string, secret []byte) error {
+	cred := wincred.NewGenericCredential(name)
+	cred.CredentialBlob = secret
+	return cred.Write()
+}
+
+// Removes the secret with the given name from the Windows credential store.
+func (t *_CredentialManagerStore) Delete(name string) error {
+	cred, err := wincred.GetGenericCredential(name)
+	if err != nil {
+		return nil
+	}
+	return cred.Delete()
+}
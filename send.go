@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Time to wait for the acknowledgement of a key, by default.
+const DEFAULT_ACK_TIMEOUT = 2 * time.Second
+
+// Length of the random tokens matching acknowledgements to keys.
+const ACK_TOKEN_SIZE = 8
+
+// Sends the keys given on the command line to the remote host of the client configuration.
+// No keys are intercepted. The program exits with a non-zero code, if a key couldn't be
+// sent or, using -ack, wasn't acknowledged by the server.
+func SendKeys(args []string) {
+	flags := flag.NewFlagSet("send", flag.ExitOnError)
+	ack := flags.Bool("ack", false, "wait until the server acknowledges each key")
+	timeout := flags.Duration("timeout", DEFAULT_ACK_TIMEOUT, "time to wait for each acknowledgement")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Missing keys, usage: keyfwd send [-ack] [-timeout <duration>] <key> [<key>...]")
+		os.Exit(EXIT_USAGE)
+	}
+	keys := make([]Key, 0, flags.NArg())
+	for _, name := range flags.Args() {
+		key, err := ParseKey(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(EXIT_USAGE)
+		}
+		keys = append(keys, key)
+	}
+
+	configuration, _, err := LoadEffectiveClientConfiguration(GetConfigStore())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := configuration.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := newSender(configuration).SendKeys(keys, *ack, *timeout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Returns a client sending keys on demand. The client doesn't intercept keys.
+func newSender(config *ClientConfiguration) *_Client {
+	ret := new(_Client)
+	ret.counters = NewCounters()
+	ret.configuration = config
+	return ret
+}
+
+// Sends the given keys to the remote host, one after another.
+// If ack is set, the acknowledgement of each key is awaited up to the given timeout,
+// before the next key is sent.
+// Returns an error in case sending failed, an acknowledgement is missing or the server
+// didn't emit a key.
+func (t *_Client) SendKeys(keys []Key, ack bool, timeout time.Duration) error {
+	// Every key is checked before sending the first one, so a batch doesn't fail halfway.
+	var err error
+	messages := make([]Message, len(keys))
+	for i, key := range keys {
+		messages[i] = keyMessage(key)
+		messages[i].Device = t.configuration.DeviceName
+		if ack {
+			if messages[i].Ack, err = newAckToken(); err != nil {
+				return err
+			}
+		}
+		if _, err := EncodeMessage(messages[i], t.configuration.Traffic.PacketSize); err != nil {
+			return fmt.Errorf("unable to send key %s: %s (increase Traffic.PacketSize)", key, err)
+		}
+	}
+
+	t.connection, err = dialRemote(t.configuration)
+	if err != nil {
+		return err
+	}
+	defer t.connection.Close()
+	t.encryption.Initialize(t.configuration.Secret)
+
+	for i, key := range keys {
+		logDebug("Sending key %s to %s", key, t.connection.RemoteAddr())
+		msg := messages[i]
+		if err := t.send(msg); err != nil {
+			return fmt.Errorf("unable to send key %s: %s", key, err)
+		}
+		if ack {
+			if err := t.awaitAck(msg.Ack, timeout); err != nil {
+				return fmt.Errorf("key %s: %s", key, err)
+			}
+			logDebug("Key %s acknowledged", key)
+		}
+	}
+	return nil
+}
+
+// Waits for the acknowledgement of the given token.
+// Packets, which can't be decrypted or belong to other tokens, are skipped.
+// Returns an error, if the acknowledgement doesn't arrive in time or reports that the key
+// wasn't emitted.
+func (t *_Client) awaitAck(token string, timeout time.Duration) error {
+	t.connection.SetReadDeadline(time.Now().Add(timeout))
+	var buf [PACKET_MAX_SIZE + 1]byte
+	for {
+		rlen, err := t.connection.Read(buf[:])
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return fmt.Errorf("no acknowledgement within %s (the server may be unreachable or of an older version)", timeout)
+		}
+		if err != nil {
+			return err
+		}
+		keyID, payload, err := DecodePacket(buf[:rlen])
+		if err != nil || keyID != t.configuration.KeyID {
+			continue
+		}
+		plain, err := t.encryption.Decrypt(payload)
+		if err != nil {
+			continue
+		}
+		var msg Message
+		if json.Unmarshal(plain, &msg) != nil || msg.Type != MESSAGE_ACK || msg.Ack != token {
+			continue
+		}
+		if len(msg.Error) > 0 {
+			return fmt.Errorf("rejected by the server: %s", msg.Error)
+		}
+		return nil
+	}
+}
+
+// Returns a new, random acknowledgement token.
+func newAckToken() (string, error) {
+	token := make([]byte, ACK_TOKEN_SIZE)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
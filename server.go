@@ -1,42 +1,420 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"net"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Interval for logging rejected packets of the same sender.
+const REJECTED_LOG_INTERVAL = time.Minute
+
+// Interval for logging rejected keys of the same sender and key.
+const AUDIT_LOG_INTERVAL = 10 * time.Second
+
+// Interval for logging the heartbeats of the same sender.
+const HEARTBEAT_LOG_INTERVAL = 10 * time.Minute
+
+// Names of the server's status counters.
+const (
+	COUNTER_RECEIVED            = "received"
+	COUNTER_EMITTED             = "emitted"
+	COUNTER_REJECTED_ADDRESS    = "rejected_address"
+	COUNTER_REJECTED_DEVICE     = "rejected_device"
+	COUNTER_REJECTED_KEY        = "rejected_key"
+	COUNTER_REJECTED_KEY_ID     = "rejected_key_id"
+	COUNTER_DECRYPTION_FAILED   = "decryption_failed"
+	COUNTER_MALFORMED_TRUNCATED = "malformed_truncated"
+	COUNTER_MALFORMED_OVERSIZED = "malformed_oversized"
+	COUNTER_MALFORMED_VERSION   = "malformed_version"
+	COUNTER_RATE_LIMITED_SENDER = "rate_limited_sender"
+	COUNTER_RATE_LIMITED_KEY    = "rate_limited_key"
+	COUNTER_RATE_LIMITED_GLOBAL = "rate_limited_global"
+	COUNTER_DROPPED_BANNED      = "dropped_banned"
+	COUNTER_BANS                = "bans"
+	COUNTER_HEARTBEATS          = "heartbeats"
+	COUNTER_COVER               = "cover"
+	COUNTER_UNKNOWN_MESSAGE     = "unknown_message"
+	COUNTER_UNSUPPORTED_KEY     = "unsupported_key"
+	COUNTER_ACKS                = "acks"
 )
 
 type _Server struct {
+	settings     *_ServerSettings
+	lock         sync.RWMutex
+	emitter      *KeyboardEmitter
+	limiter      *_RateLimiter
+	rejectedLog  *_RateLimitedLog
+	auditLog     *_RateLimitedLog
+	heartbeatLog *_RateLimitedLog
+	counters     *_Counters
+	queue        *_FairScheduler
+	started      time.Time
+}
+
+// Settings of the server, which are replaced as a whole on reload.
+type _ServerSettings struct {
 	configuration *ServerConfiguration
-	encryption    Encryption
-	emitter       *KeyboardEmitter
+	keyring       *_Keyring
+	access        *_AccessControl
+}
+
+// Packet received by the server, waiting to be decrypted and emitted.
+// The packet is handled using the settings in effect when it was received, so packets
+// queued before a reload are still accepted.
+type _ReceivedPacket struct {
+	data     []byte
+	remote   *net.UDPAddr
+	sock     *net.UDPConn
+	settings *_ServerSettings
+}
+
+// Returns the settings of the given configuration.
+// Returns an error, if the access control lists are invalid.
+func newServerSettings(config *ServerConfiguration) (*_ServerSettings, error) {
+	access, err := NewAccessControl(config)
+	if err != nil {
+		return nil, err
+	}
+	ret := new(_ServerSettings)
+	ret.configuration = config
+	ret.keyring = NewKeyring(config)
+	ret.access = access
+	return ret, nil
 }
 
 func NewServer(config *ServerConfiguration) *_Server {
 	ret := new(_Server)
-	ret.configuration = config
+	ret.settings = &_ServerSettings{configuration: config}
 	ret.emitter = NewKeyboardEmitter()
+	ret.limiter = NewRateLimiter(config.RateLimits)
+	ret.rejectedLog = NewRateLimitedLog(REJECTED_LOG_INTERVAL)
+	ret.auditLog = NewRateLimitedLog(AUDIT_LOG_INTERVAL)
+	ret.heartbeatLog = NewRateLimitedLog(HEARTBEAT_LOG_INTERVAL)
+	ret.counters = NewCounters()
+	ret.queue = NewFairScheduler("receive", config.Queue,
+		time.Duration(config.EmitDeadlineMilliseconds)*time.Millisecond, ret.counters)
 	return ret
 }
 
-func (t *_Server) Start() error {
-	t.encryption.Initialize(t.configuration.Secret)
-	var buf [1024]byte
-	addr, _ := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", t.configuration.Port))
-	log.Println(fmt.Sprintf("Listening on port %d", t.configuration.Port))
-	sock, _ := net.ListenUDP("udp", addr)
+// Starts the server.
+// The function listens on each configured address and emits the keys received from
+// the clients.
+// Received packets are passed to a separate goroutine, which decrypts them and emits the
+// contained keys, so a slow emitter doesn't stall the sockets. Packets of the same sender
+// are emitted in order, while the senders take turns. Packets waiting longer than the
+// configured deadline are dropped.
+// The function blocks until the given context is cancelled. The listening sockets are
+// closed then and packets, which were already received, are still emitted before the
+// function returns.
+// Returns an error in case one of the listening sockets could not be opened or failed.
+func (t *_Server) Start(ctx context.Context) error {
+	settings, err := newServerSettings(t.current().configuration)
+	if err != nil {
+		return err
+	}
+	t.setCurrent(settings)
+	addrs, err := ListenAddresses(settings.configuration)
+	if err != nil {
+		return err
+	}
+	network := getNetwork(settings.configuration.Network)
+	sockets := make([]*net.UDPConn, 0, len(addrs))
+	for _, addr := range addrs {
+		sock, err := net.ListenUDP(network, addr)
+		if err != nil {
+			for _, s := range sockets {
+				s.Close()
+			}
+			return err
+		}
+		logInfo("Listening on %s (%s)", sock.LocalAddr().String(), network)
+		sockets = append(sockets, sock)
+	}
+
+	t.started = time.Now()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go ReportStatus(ctx, t.Status)
+
+	emitterDone := make(chan bool)
+	go func() {
+		for {
+			event, ok := t.queue.Pop()
+			if !ok {
+				break
+			}
+			packet := event.(*_ReceivedPacket)
+			t.handlePacket(packet.settings, packet.data, packet.remote, packet.sock)
+		}
+		close(emitterDone)
+	}()
+
+	errs := make(chan error, len(sockets))
+	var wg sync.WaitGroup
+	for _, sock := range sockets {
+		wg.Add(1)
+		go func(sock *net.UDPConn) {
+			defer wg.Done()
+			if err := t.receive(ctx, sock); err != nil {
+				errs <- err
+				cancel()
+			}
+		}(sock)
+	}
+
+	<-ctx.Done()
+	logInfo("Closing listening sockets")
+	for _, sock := range sockets {
+		sock.Close()
+	}
+	wg.Wait()
+	t.queue.Close()
+	<-emitterDone
+	if err := WriteStatus(t.Status()); err != nil {
+		logError("Unable to write status file: %s", err)
+	}
+	close(errs)
+	return <-errs
+}
+
+// Reads and handles the packets arriving at the given socket until the given context
+// is cancelled.
+// Returns an error in case the socket was closed unexpectedly.
+func (t *_Server) receive(ctx context.Context, sock *net.UDPConn) error {
+	var buf [PACKET_MAX_SIZE + 1]byte
 	for {
 		rlen, remote, err := sock.ReadFromUDP(buf[:])
-		if err == nil {
-			var msg Message
-			if json.Unmarshal(t.encryption.Decrypt(buf[0:rlen]), &msg) == nil {
-				log.Println(fmt.Sprintf("Received key from host '%s': %d ", remote.IP.String(), msg.VkCode))
-				t.emitter.SendKey(msg.VkCode)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			// Errors of single packets (e.g. ICMP port unreachable on Windows) don't
+			// affect the socket.
+			if errors.Is(err, net.ErrClosed) {
+				return err
 			}
+			continue
+		}
+		settings := t.current()
+		if t.admitPacket(settings, remote) {
+			t.queue.Push(remote.IP.String(), &_ReceivedPacket{append([]byte(nil), buf[0:rlen]...), remote, sock, settings})
 		}
 	}
 }
 
-func (t *_Server) Stop() {
+// Checks, whether packets of the given sender are accepted at all.
+// Called before queueing the packet, so banned, rejected or flooding senders can't fill
+// the queue.
+func (t *_Server) admitPacket(settings *_ServerSettings, remote *net.UDPAddr) bool {
+	sender := remote.IP.String()
+	t.counters.Inc(COUNTER_RECEIVED)
+	if t.limiter.IsBanned(sender) {
+		t.counters.Inc(COUNTER_DROPPED_BANNED)
+		return false
+	}
+	if err := settings.access.CheckAddress(remote.IP); err != nil {
+		t.counters.Inc(COUNTER_REJECTED_ADDRESS)
+		t.rejectedLog.Printf(sender, "Rejected packet from host '%s': %s", sender, err)
+		return false
+	}
+	if err := t.limiter.AllowSender(sender); err != nil {
+		t.counters.Inc(COUNTER_RATE_LIMITED_SENDER)
+		t.rejectedLog.Printf(sender, "Dropped packet from host '%s': %s", sender, err)
+		return false
+	}
+	return true
+}
+
+// Decrypts the given packet and emits the contained key, if the sender is allowed to.
+// Key messages asking for an acknowledgement are answered using the given socket.
+func (t *_Server) handlePacket(settings *_ServerSettings, data []byte, remote *net.UDPAddr, sock *net.UDPConn) {
+	sender := remote.IP.String()
+	entry, msg, err := ParsePacket(data, settings.keyring)
+	if err != nil {
+		t.counters.Inc(packetErrorCounter(err))
+		t.rejectedLog.Printf(sender, "Rejected packet from host '%s': %s", sender, err)
+		t.recordFailure(settings, sender)
+		return
+	}
+	keyID := entry.name
+	t.limiter.RecordSuccess(sender)
+	if err := settings.access.CheckDevice(msg.Device); err != nil {
+		t.counters.Inc(COUNTER_REJECTED_DEVICE)
+		t.rejectedLog.Printf(sender, "Rejected key from host '%s': %s", sender, err)
+		if msg.Type == MESSAGE_KEY && len(msg.Ack) > 0 {
+			t.acknowledge(sock, remote, entry, msg.Ack, err)
+		}
+		return
+	}
+	switch msg.Type {
+	case MESSAGE_KEY:
+	case MESSAGE_HEARTBEAT:
+		t.limiter.RefundSender(sender)
+		t.counters.Inc(COUNTER_HEARTBEATS)
+		t.heartbeatLog.Printf(sender, "Heartbeat from host '%s' (device '%s', key ID '%s')", sender, msg.Device, keyID)
+		return
+	case MESSAGE_COVER:
+		t.limiter.RefundSender(sender)
+		t.counters.Inc(COUNTER_COVER)
+		return
+	default:
+		t.counters.Inc(COUNTER_UNKNOWN_MESSAGE)
+		return
+	}
+	err = t.emitKey(settings, entry, sender, msg)
+	if len(msg.Ack) > 0 {
+		t.acknowledge(sock, remote, entry, msg.Ack, err)
+	}
+}
+
+// Emits the key of the given key message, if the given client entry and the message's
+// device are allowed to. Unknown keys are rejected like keys, which are not allowed.
+// Returns the reason, if the key was not emitted.
+func (t *_Server) emitKey(settings *_ServerSettings, entry *_KeyringEntry, sender string, msg Message) error {
+	device := msg.Device
+	key, err := msg.PressedKey()
+	if err != nil {
+		key = Key(msg.Key)
+		err = fmt.Errorf("key %s is not allowed: %s", key, err)
+	} else {
+		err = t.checkKey(settings, entry, device, key)
+	}
+	if err != nil {
+		t.counters.Inc(COUNTER_REJECTED_KEY)
+		t.auditLog.Printf(fmt.Sprintf("%s/%s", sender, key), "AUDIT: Rejected key from host '%s': %s", sender, err)
+		return err
+	}
+	if err := t.limiter.AllowKey(sender, key); err != nil {
+		if errors.Is(err, ErrGlobalRateLimit) {
+			t.counters.Inc(COUNTER_RATE_LIMITED_GLOBAL)
+		} else {
+			t.counters.Inc(COUNTER_RATE_LIMITED_KEY)
+		}
+		t.rejectedLog.Printf(sender, "Dropped key %s from host '%s': %s", key, sender, err)
+		return err
+	}
+	logInfo("Accepted key from host '%s' (device '%s', key ID '%s'): %s", sender, device, entry.name, key)
+	if err := t.emitter.SendKey(key); err != nil {
+		t.counters.Inc(COUNTER_UNSUPPORTED_KEY)
+		t.rejectedLog.PrintError(sender, "Unable to emit key %s from host '%s': %s", key, sender, err)
+		return err
+	}
+	t.counters.Inc(COUNTER_EMITTED)
+	return nil
+}
+
+// Sends the acknowledgement of the given token to the given sender, encrypted using the
+// secret of the sender's key ID. The given error is passed along, if the key was not emitted.
+// Only senders, whose packets were decrypted, are answered.
+func (t *_Server) acknowledge(sock *net.UDPConn, remote *net.UDPAddr, entry *_KeyringEntry, token string, result error) {
+	msg := Message{Type: MESSAGE_ACK, Ack: token}
+	if result != nil {
+		msg.Error = result.Error()
+	}
+	data, err := EncodeMessage(msg, ACK_MESSAGE_SIZE)
+	if err != nil {
+		msg.Error = "the key was not emitted"
+		data, _ = EncodeMessage(msg, ACK_MESSAGE_SIZE)
+	}
+	if _, err := sock.WriteToUDP(EncodePacket(entry.name, entry.encryption.Encrypt(data)), remote); err != nil {
+		logDebug("Unable to acknowledge key to host '%s': %s", remote.IP, err)
+		return
+	}
+	t.counters.Inc(COUNTER_ACKS)
+}
+
+// Returns the name of the counter for the given packet decoding error.
+func packetErrorCounter(err error) string {
+	switch {
+	case errors.Is(err, ErrPacketTruncated):
+		return COUNTER_MALFORMED_TRUNCATED
+	case errors.Is(err, ErrPacketOversized):
+		return COUNTER_MALFORMED_OVERSIZED
+	case errors.Is(err, ErrPacketVersion):
+		return COUNTER_MALFORMED_VERSION
+	case errors.Is(err, ErrPacketKeyID):
+		return COUNTER_REJECTED_KEY_ID
+	}
+	return COUNTER_DECRYPTION_FAILED
+}
+
+// Records a decryption failure of the given sender and bans it after repeated failures.
+func (t *_Server) recordFailure(settings *_ServerSettings, sender string) {
+	if t.limiter.RecordFailure(sender) {
+		t.counters.Inc(COUNTER_BANS)
+		logInfo("Banned host '%s' for %d seconds after repeated decryption failures",
+			sender, settings.configuration.RateLimits.BanSeconds)
+	}
+}
+
+// Checks, whether the given device may emit the given key.
+// The permitted keys of a client entry take precedence over the permitted keys of the
+// device and the server.
+func (t *_Server) checkKey(settings *_ServerSettings, entry *_KeyringEntry, device string, key Key) error {
+	if entry.allowedKeys == nil {
+		return settings.access.CheckKey(device, key)
+	}
+	for _, allowed := range entry.allowedKeys {
+		if allowed == key {
+			return nil
+		}
+	}
+	return fmt.Errorf("key %s is not allowed for client '%s'", key, entry.name)
+}
+
+// Applies the given configuration to the running server.
+// Keys, access control lists and rate limits take effect for packets received from now on.
+// The listening addresses, the network and the queue settings take effect after a restart.
+// Returns an error, if the new configuration is invalid. The current configuration is
+// kept then.
+func (t *_Server) Reload(config *ServerConfiguration) error {
+	settings, err := newServerSettings(config)
+	if err != nil {
+		return err
+	}
+	previous := t.current().configuration
+	if config.Port != previous.Port || config.Network != previous.Network ||
+		!reflect.DeepEqual(config.ListenAddresses, previous.ListenAddresses) {
+		logInfo("The new listening addresses take effect after a restart")
+	}
+	if config.Queue != previous.Queue || config.EmitDeadlineMilliseconds != previous.EmitDeadlineMilliseconds {
+		logInfo("The new queue settings take effect after a restart")
+	}
+	if config.RateLimits != previous.RateLimits {
+		t.limiter.SetLimits(config.RateLimits)
+	}
+	t.setCurrent(settings)
+	logInfo("Configuration reloaded")
+	return nil
+}
+
+// Returns the settings currently in effect.
+func (t *_Server) current() *_ServerSettings {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.settings
+}
+
+// Replaces the settings currently in effect.
+func (t *_Server) setCurrent(settings *_ServerSettings) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.settings = settings
+}
+
+// Returns the current status of the server.
+func (t *_Server) Status() *Status {
+	return &Status{
+		Role:     "server",
+		Pid:      os.Getpid(),
+		Started:  t.started,
+		Updated:  time.Now(),
+		Counters: t.counters.Snapshot(),
+	}
 }
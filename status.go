@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Interval for writing the status of a running client or server.
+const STATUS_INTERVAL = time.Minute
+
+// Named event counters.
+type _Counters struct {
+	lock   sync.Mutex
+	values map[string]uint64
+}
+
+func NewCounters() *_Counters {
+	ret := new(_Counters)
+	ret.values = make(map[string]uint64)
+	return ret
+}
+
+// Increments the counter with the given name.
+func (t *_Counters) Inc(name string) {
+	t.lock.Lock()
+	t.values[name]++
+	t.lock.Unlock()
+}
+
+// Returns a copy of all counter values.
+func (t *_Counters) Snapshot() map[string]uint64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	ret := make(map[string]uint64, len(t.values))
+	for name, value := range t.values {
+		ret[name] = value
+	}
+	return ret
+}
+
+// Status of a running client or server, as written to the status file.
+type Status struct {
+	Role     string
+	Pid      int
+	Started  time.Time
+	Updated  time.Time
+	Counters map[string]uint64
+}
+
+// Returns a one-line summary of the status counters.
+func (t *Status) String() string {
+	names := make([]string, 0, len(t.Counters))
+	for name := range t.Counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%d", name, t.Counters[name]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Returns the path of the status file for the given role (client or server).
+func StatusFilePath(role string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "keyfwd", role+".status")
+}
+
+// Writes the given status to the status file of its role.
+// The status is written to a temporary file first, which replaces the status file, so
+// readers never see a partial status.
+func WriteStatus(status *Status) error {
+	path := StatusFilePath(status.Role)
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".status-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Reads the status file of the given role.
+func ReadStatus(role string) (*Status, error) {
+	data, err := os.ReadFile(StatusFilePath(role))
+	if err != nil {
+		return nil, err
+	}
+	ret := new(Status)
+	err = json.Unmarshal(data, ret)
+	return ret, err
+}
+
+// Prints the last written status of the client and server.
+func PrintStatus() {
+	for _, role := range []string{"client", "server"} {
+		status, err := ReadStatus(role)
+		if err != nil {
+			fmt.Printf("%-10s: no status available\n", role)
+			continue
+		}
+		fmt.Printf("%-10s: pid %d, started %s, updated %s\n", role, status.Pid,
+			status.Started.Format(time.RFC3339), status.Updated.Format(time.RFC3339))
+		names := make([]string, 0, len(status.Counters))
+		for name := range status.Counters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("  %-24s %d\n", name, status.Counters[name])
+		}
+	}
+}
+
+// Periodically writes the status, returned by the given function, to the log and the
+// status file, until the given context is cancelled.
+func ReportStatus(ctx context.Context, status func() *Status) {
+	ticker := time.NewTicker(STATUS_INTERVAL)
+	defer ticker.Stop()
+	for {
+		if err := WriteStatus(status()); err != nil {
+			logError("Unable to write status file: %s", err)
+		}
+		select {
+		case <-ticker.C:
+			logInfo("Status: %s", status())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
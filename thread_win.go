@@ -0,0 +1,45 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"github.com/AllenDang/w32"
+	"runtime"
+	"syscall"
+)
+
+var (
+	modKernel32            = syscall.NewLazyDLL("kernel32.dll")
+	procGetCurrentThreadId = modKernel32.NewProc("GetCurrentThreadId")
+	procPostThreadMessage  = modUser32.NewProc("PostThreadMessageW")
+)
+
+func init() {
+	// Windows delivers the messages of a window to the thread, which created it.
+	// Keep the main goroutine on the main thread, so the notify icon window and its
+	// message loop share the same thread.
+	runtime.LockOSThread()
+}
+
+// Returns the ID of the calling thread:
+// http://msdn.microsoft.com/en-us/library/windows/desktop/ms683183(v=vs.85).aspx
+func getCurrentThreadId() uint32 {
+	ret, _, _ := procGetCurrentThreadId.Call()
+	return uint32(ret)
+}
+
+// Posts the WM_QUIT message to the message queue of the given thread, causing its
+// GetMessage loop to return:
+// http://msdn.microsoft.com/en-us/library/windows/desktop/ms644946(v=vs.85).aspx
+func postThreadQuit(threadId uint32) error {
+	ret, _, _ := procPostThreadMessage.Call(
+		uintptr(threadId),
+		uintptr(w32.WM_QUIT),
+		0,
+		0)
+	if ret == 0 {
+		return syscall.GetLastError()
+	}
+	return nil
+}
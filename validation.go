@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Stored configuration value, which can't be decoded.
+// Storing a configuration loaded with such errors would lose the affected values.
+var ErrConfigurationUnreadable = errors.New("unreadable value")
+
+// Problem with a single field of a configuration.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (t *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", t.Field, t.Err)
+}
+
+func (t *FieldError) Unwrap() error {
+	return t.Err
+}
+
+// All problems found while loading or validating a configuration.
+type ConfigurationError struct {
+	Role     string
+	Problems []*FieldError
+}
+
+func (t *ConfigurationError) Error() string {
+	lines := make([]string, 0, len(t.Problems)+1)
+	lines = append(lines, fmt.Sprintf("invalid %s configuration:", t.Role))
+	for _, problem := range t.Problems {
+		lines = append(lines, "  "+problem.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (t *ConfigurationError) Unwrap() []error {
+	ret := make([]error, len(t.Problems))
+	for i, problem := range t.Problems {
+		ret[i] = problem
+	}
+	return ret
+}
+
+// Adds a problem with the given field. Nil errors are ignored.
+func (t *ConfigurationError) Add(field string, err error) {
+	if err != nil {
+		t.Problems = append(t.Problems, &FieldError{field, err})
+	}
+}
+
+// Adds a problem with the given field, described by the given format string.
+func (t *ConfigurationError) Addf(field string, format string, a ...interface{}) {
+	t.Add(field, fmt.Errorf(format, a...))
+}
+
+// Returns the configuration error or nil, if no problems were found.
+func (t *ConfigurationError) Err() error {
+	if len(t.Problems) == 0 {
+		return nil
+	}
+	return t
+}
+
+// Checks all fields of the client configuration.
+// Returns a ConfigurationError listing every problem, or nil.
+func (t *ClientConfiguration) Validate() error {
+	problems := &ConfigurationError{Role: "client"}
+	t.validate(problems)
+	return problems.Err()
+}
+
+func (t *ClientConfiguration) validate(problems *ConfigurationError) {
+	validatePort(problems, "Port", t.Port)
+	if len(t.Secret) == 0 {
+		problems.Addf("Secret", "missing secret")
+	}
+	if len(strings.TrimSpace(t.Hostname)) == 0 {
+		problems.Addf("Hostname", "missing hostname")
+	} else if remote, err := ResolveRemoteAddress(t.Hostname, t.Port); err != nil {
+		problems.Addf("Hostname", "unable to resolve '%s': %s", t.Hostname, err)
+	} else if _, err := ResolveSourceAddress(t.SourceAddress, remote); err != nil {
+		problems.Add("SourceAddress", err)
+	}
+	if len(t.ForwardedKeys) == 0 {
+		problems.Addf("ForwardedKeys", "no keys to forward")
+	}
+	validateKeys(problems, "ForwardedKeys", t.ForwardedKeys)
+	if len(t.KeyID) > PACKET_MAX_KEY_ID {
+		problems.Addf("KeyID", "longer than %d bytes", PACKET_MAX_KEY_ID)
+	}
+	if t.Traffic.PacketSize < 0 || t.Traffic.PacketSize > PACKET_MAX_SIZE/2 {
+		problems.Addf("Traffic.PacketSize", "must be between 0 and %d", PACKET_MAX_SIZE/2)
+	} else if t.Traffic.PacketSize > 0 {
+		// Keys sent by 'keyfwd send -ack' carry an acknowledgement token in addition.
+		for _, key := range t.ForwardedKeys {
+			msg := keyMessage(key)
+			msg.Device = t.DeviceName
+			msg.Ack = strings.Repeat("0", hex.EncodedLen(ACK_TOKEN_SIZE))
+			if _, err := EncodeMessage(msg, t.Traffic.PacketSize); err != nil {
+				problems.Addf("Traffic.PacketSize", "too small for key %s of device '%s': %s", key, t.DeviceName, err)
+				break
+			}
+		}
+	}
+	if t.Traffic.HeartbeatSeconds < 0 {
+		problems.Addf("Traffic.HeartbeatSeconds", "must not be negative")
+	}
+	if t.Traffic.CoverMilliseconds < 0 {
+		problems.Addf("Traffic.CoverMilliseconds", "must not be negative")
+	}
+	validateQueue(problems, "Queue", t.Queue)
+}
+
+// Checks all fields of the server configuration.
+// Returns a ConfigurationError listing every problem, or nil.
+func (t *ServerConfiguration) Validate() error {
+	problems := &ConfigurationError{Role: "server"}
+	t.validate(problems)
+	return problems.Err()
+}
+
+func (t *ServerConfiguration) validate(problems *ConfigurationError) {
+	validatePort(problems, "Port", t.Port)
+	if !t.hasSecret(time.Now()) {
+		problems.Addf("Secret", "missing secret (no valid default secret, key generation or client entry)")
+	}
+	switch t.Network {
+	case "", NETWORK_DUAL_STACK, NETWORK_IPV4_ONLY, NETWORK_IPV6_ONLY:
+		if _, err := ListenAddresses(t); err != nil {
+			problems.Add("ListenAddresses", err)
+		}
+	default:
+		problems.Addf("Network", "unknown network '%s'", t.Network)
+	}
+	if _, err := parseNetworks(t.AllowedNetworks); err != nil {
+		problems.Add("AllowedNetworks", err)
+	}
+	if _, err := parseNetworks(t.DeniedNetworks); err != nil {
+		problems.Add("DeniedNetworks", err)
+	}
+	validateKeys(problems, "AllowedKeys", t.AllowedKeys)
+	for device, keys := range t.SenderKeys {
+		validateKeys(problems, fmt.Sprintf("SenderKeys[%s]", device), keys)
+	}
+	// Client entries and key generations share the key IDs of the keyring.
+	generations := make(map[string]bool, len(t.KeyGenerations))
+	for _, generation := range t.KeyGenerations {
+		if generations[generation.ID] {
+			problems.Addf(fmt.Sprintf("KeyGenerations[%s]", generation.ID), "duplicate key generation ID")
+		}
+		generations[generation.ID] = true
+	}
+	names := make(map[string]bool, len(t.Clients))
+	for _, client := range t.Clients {
+		field := fmt.Sprintf("Clients[%s]", client.Name)
+		switch {
+		case len(client.Name) == 0 || len(client.Name) > PACKET_MAX_KEY_ID:
+			problems.Addf(field, "name must have 1 to %d bytes", PACKET_MAX_KEY_ID)
+		case names[client.Name]:
+			problems.Addf(field, "duplicate client name")
+		case generations[client.Name]:
+			problems.Addf(field, "name is used by a key generation")
+		case client.Enabled && len(client.Secret) == 0:
+			problems.Addf(field, "missing secret")
+		}
+		names[client.Name] = true
+		validateKeys(problems, field+".AllowedKeys", client.AllowedKeys)
+	}
+	for _, generation := range t.KeyGenerations {
+		if len(generation.Secret) == 0 {
+			problems.Addf(fmt.Sprintf("KeyGenerations[%s]", generation.ID), "missing secret")
+		}
+	}
+	limits := t.RateLimits
+	if limits.SenderRate < 0 || limits.KeyRate < 0 || limits.GlobalRate < 0 ||
+		limits.SenderBurst < 0 || limits.KeyBurst < 0 || limits.GlobalBurst < 0 ||
+		limits.BanThreshold < 0 || limits.BanSeconds < 0 {
+		problems.Addf("RateLimits", "limits must not be negative")
+	}
+	validateQueue(problems, "Queue", t.Queue)
+	if t.EmitDeadlineMilliseconds < 0 {
+		problems.Addf("EmitDeadlineMilliseconds", "must not be negative")
+	}
+}
+
+// Returns true, if the server has at least one secret, which is valid at the given time.
+func (t *ServerConfiguration) hasSecret(now time.Time) bool {
+	if _, secret := t.newestSecret(now); len(secret) > 0 {
+		return true
+	}
+	for _, client := range t.Clients {
+		if len(client.Secret) > 0 && client.Enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the newest secret of the server, which is valid at the given time, along with
+// its key ID: the key generation created last or, without a valid generation, the default
+// secret with an empty key ID. Returns no secret, if none is valid.
+func (t *ServerConfiguration) newestSecret(now time.Time) (string, []byte) {
+	var ret *KeyGeneration
+	for i := range t.KeyGenerations {
+		generation := &t.KeyGenerations[i]
+		if len(generation.Secret) > 0 && generation.IsValid(now) && (ret == nil || !generation.NotBefore.Before(ret.NotBefore)) {
+			ret = generation
+		}
+	}
+	if ret != nil {
+		return ret.ID, ret.Secret
+	}
+	if len(t.Secret) > 0 && (t.SecretNotAfter.IsZero() || now.Before(t.SecretNotAfter)) {
+		return "", t.Secret
+	}
+	return "", nil
+}
+
+func validatePort(problems *ConfigurationError, field string, port uint64) {
+	if port == 0 || port > 65535 {
+		problems.Addf(field, "port %d out of range (1-65535)", port)
+	}
+}
+
+func validateKeys(problems *ConfigurationError, field string, keys []Key) {
+	for _, key := range keys {
+		if !key.IsValid() {
+			problems.Addf(field, "unknown key '%s'", key)
+		}
+	}
+}
+
+func validateQueue(problems *ConfigurationError, field string, queue QueueConfiguration) {
+	if queue.Size < 1 {
+		problems.Addf(field+".Size", "must be at least 1")
+	}
+	switch queue.DropPolicy {
+	case DROP_OLDEST, DROP_NEWEST, DROP_BLOCK:
+	default:
+		problems.Addf(field+".DropPolicy", "unknown drop policy '%s'", queue.DropPolicy)
+	}
+	if queue.BlockTimeoutMilliseconds < 0 {
+		problems.Addf(field+".BlockTimeoutMilliseconds", "must not be negative")
+	}
+}